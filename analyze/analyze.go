@@ -25,17 +25,18 @@ SOFTWARE.
 package analyze
 
 import (
-	"errors"
-	"fmt"
-	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/tm"
 	"github.com/ivandejanovic/mlpl/types"
 )
 
 type procNode func(buf *buffer, node *types.TreeNode)
 
 type buffer struct {
-	location  int
-	bucketMap map[string]types.Bucket
+	location    int
+	bucketMap   map[string]types.Bucket
+	global      *buffer             // Enclosing global scope while inside a procedure, nil otherwise
+	diagnostics *[]types.Diagnostic // Shared by the global buffer and the buffers of procedure scopes
+	probe       bool                // Learning the types assignments give, a use does not fix the type of a variable
 }
 
 func (buf *buffer) st_insert(name string, lineno int) {
@@ -46,10 +47,10 @@ func (buf *buffer) st_insert(name string, lineno int) {
 		for line != nil {
 			line = line.Next
 		}
-		line = &types.LineList{lineno, nil}
+		line = &types.LineList{Lineno: lineno, Next: nil}
 	} else {
-		line := types.LineList{lineno, nil}
-		bucket = types.Bucket{name, &line, buf.location}
+		line := types.LineList{Lineno: lineno, Next: nil}
+		bucket = types.Bucket{Name: name, Lines: &line, MemLoc: buf.location, Kind: types.VarSym, Size: 1, Type: types.Void}
 		buf.location = buf.location + 1
 		buf.bucketMap[name] = bucket
 	}
@@ -65,33 +66,224 @@ func (buf *buffer) st_lookup(name string) int {
 	return -1
 }
 
-func typeError(lineno int, message string) {
-	errorMessage := fmt.Sprintf(locale.Locale.AnalyzeTypePrefixError, lineno, message)
-	err := errors.New(errorMessage)
-	panic(err)
+// Function procLookup finds a procedure by name in the global scope
+func (buf *buffer) procLookup(name string) (types.Bucket, bool) {
+	bucketMap := buf.bucketMap
+	if buf.global != nil {
+		bucketMap = buf.global.bucketMap
+	}
+
+	bucket, ok := bucketMap[name]
+	if !ok || bucket.Kind != types.ProcSym {
+		return bucket, false
+	}
+
+	return bucket, true
+}
+
+// Procedure declareProc enters a procedure and its parameters into the symbol table
+func (buf *buffer) declareProc(node *types.TreeNode) {
+	if _, ok := buf.bucketMap[node.Name]; ok {
+		buf.typeError(node, "AnalyzeProcRedefinedError", node.Name)
+		return
+	}
+
+	line := types.LineList{Lineno: node.Lineno, Next: nil}
+	bucket := types.Bucket{Name: node.Name, Lines: &line, MemLoc: 0, Kind: types.ProcSym}
+	bucket.Params = make([]string, 0, len(node.Children)-1)
+	bucket.Scope = make(map[string]types.Bucket)
+
+	for index := 0; index < len(node.Children)-1; index++ {
+		param := node.Children[index]
+		if _, ok := bucket.Scope[param.Name]; ok {
+			buf.typeError(param, "AnalyzeProcParamError", param.Name)
+			continue
+		}
+		paramLine := types.LineList{Lineno: param.Lineno, Next: nil}
+		bucket.Scope[param.Name] = types.Bucket{Name: param.Name, Lines: &paramLine, MemLoc: index, Kind: types.VarSym, Size: 1, Type: types.Integer}
+		bucket.Params = append(bucket.Params, param.Name)
+	}
+
+	buf.bucketMap[node.Name] = bucket
+}
+
+// Procedure typeError records a semantic error at the position of node, analysis goes on so that all errors are reported
+func (buf *buffer) typeError(node *types.TreeNode, key string, args ...interface{}) {
+	diagnostic := types.Diagnostic{Line: node.Lineno, Column: node.Column, Severity: types.ErrorSeverity, Key: key, Args: args}
+	*buf.diagnostics = append(*buf.diagnostics, diagnostic)
+}
+
+// Procedure insertVar records a variable use in the current scope
+func (buf *buffer) insertVar(node *types.TreeNode) {
+	if _, ok := buf.procLookup(node.Name); ok {
+		buf.typeError(node, "AnalyzeProcNameError", node.Name)
+		return
+	}
+
+	if bucket, ok := buf.bucketMap[node.Name]; ok && bucket.Kind == types.ArraySym {
+		buf.typeError(node, "AnalyzeArrayIndexMissingError", node.Name)
+		return
+	}
+
+	if buf.st_lookup(node.Name) == -1 {
+		buf.st_insert(node.Name, node.Lineno)
+	} else {
+		buf.st_insert(node.Name, 0)
+	}
+}
+
+// Procedure insertElement records a use of an array element, the array must already be declared
+func (buf *buffer) insertElement(node *types.TreeNode) {
+	if bucket, ok := buf.bucketMap[node.Name]; !ok || bucket.Kind != types.ArraySym {
+		buf.typeError(node, "AnalyzeNotArrayError", node.Name)
+		return
+	}
+
+	buf.st_insert(node.Name, 0)
+}
+
+// Procedure declareArray allocates consecutive memory cells for all elements of an array
+func (buf *buffer) declareArray(node *types.TreeNode) {
+	if _, ok := buf.procLookup(node.Name); ok {
+		buf.typeError(node, "AnalyzeProcNameError", node.Name)
+		return
+	}
+	if _, ok := buf.bucketMap[node.Name]; ok {
+		buf.typeError(node, "AnalyzeArrayDeclarationError", node.Name)
+		return
+	}
+	if node.Val <= 0 {
+		buf.typeError(node, "AnalyzeArraySizeError")
+		return
+	}
+
+	// The stack needs room for temporaries above the variables
+	if free := tm.DataSize - tm.StackReserve - buf.location; node.Val > free {
+		buf.typeError(node, "AnalyzeArrayMemoryError", node.Name, node.Val, free)
+	}
+
+	line := types.LineList{Lineno: node.Lineno, Next: nil}
+	buf.bucketMap[node.Name] = types.Bucket{Name: node.Name, Lines: &line, MemLoc: buf.location, Kind: types.ArraySym, Size: node.Val, Type: types.Void}
+	buf.location = buf.location + node.Val
 }
 
 func insertNode(buf *buffer, node *types.TreeNode) {
 	switch node.Node {
 	case types.StmtK:
-		if node.Stmt == types.AssignK || node.Stmt == types.ReadK {
-			if buf.st_lookup(node.Name) == -1 {
-				buf.st_insert(node.Name, node.Lineno)
+		switch node.Stmt {
+		case types.AssignK:
+			if len(node.Children) > 1 {
+				buf.insertElement(node)
 			} else {
-				buf.st_insert(node.Name, 0)
+				buf.insertVar(node)
 			}
-		}
-	case types.ExpK:
-		if node.Exp == types.IdK {
-			if buf.st_lookup(node.Name) == -1 {
-				buf.st_insert(node.Name, node.Lineno)
+		case types.ReadK:
+			if len(node.Children) > 0 {
+				buf.insertElement(node)
 			} else {
-				buf.st_insert(node.Name, 0)
+				buf.insertVar(node)
 			}
+		case types.ArrayK:
+			buf.declareArray(node)
+		case types.ProcK:
+			buf.enterProc(node.Name)
+		case types.CallK:
+			checkCall(buf, node)
+		case types.ReturnK:
+			if buf.global == nil {
+				buf.typeError(node, "AnalyzeReturnError")
+			}
+		}
+	case types.ExpK:
+		switch node.Exp {
+		case types.IdK:
+			buf.insertVar(node)
+		case types.IndexK, types.LengthK:
+			buf.insertElement(node)
+		case types.CallExpK:
+			checkCall(buf, node)
 		}
 	}
 }
 
+// Procedure enterProc switches to the scope of a procedure body, starting after the parameters
+func (buf *buffer) enterProc(name string) {
+	bucket, _ := buf.procLookup(name)
+	global := *buf
+	*buf = buffer{len(bucket.Params), bucket.Scope, &global, buf.diagnostics, buf.probe}
+}
+
+// Function varType returns the type held by a variable, a variable that is never assigned holds an integer
+func (buf *buffer) varType(name string) types.ExpType {
+	bucket := buf.bucketMap[name]
+	if bucket.Type == types.Void && !buf.probe {
+		bucket.Type = types.Integer
+		buf.bucketMap[name] = bucket
+	}
+
+	return bucket.Type
+}
+
+// Procedure assignType fixes the type of a variable on its first assignment and checks all later ones against it
+func (buf *buffer) assignType(node *types.TreeNode, expType types.ExpType) {
+	if !isNumeric(expType) && expType != types.String && expType != types.Boolean {
+		buf.typeError(node, "AnalyzeTypeAssignError")
+	}
+
+	// An integer may be stored into a real variable, code generation converts it
+	bucket := buf.bucketMap[node.Name]
+	if bucket.Type == types.Void {
+		bucket.Type = expType
+		buf.bucketMap[node.Name] = bucket
+	} else if bucket.Type != expType && !(bucket.Type == types.Real && expType == types.Integer) {
+		buf.typeError(node, "AnalyzeTypeMismatchError", node.Name)
+	}
+	node.Type = bucket.Type
+}
+
+func isNumeric(expType types.ExpType) bool {
+	return expType == types.Integer || expType == types.Real
+}
+
+// Function numericType returns the type of arithmetic on two numbers, any real operand makes the result real
+func numericType(left types.ExpType, right types.ExpType) types.ExpType {
+	if left == types.Real || right == types.Real {
+		return types.Real
+	}
+
+	return types.Integer
+}
+
+// Procedure enterNode switches scopes for type checking
+func enterNode(buf *buffer, node *types.TreeNode) {
+	if node.Node == types.StmtK && node.Stmt == types.ProcK {
+		buf.enterProc(node.Name)
+	}
+}
+
+// Procedure leaveNode restores the global scope after a procedure body
+func leaveNode(buf *buffer, node *types.TreeNode) {
+	if node.Node == types.StmtK && node.Stmt == types.ProcK {
+		// Remember how many cells the frame needs for parameters and locals
+		bucket := buf.global.bucketMap[node.Name]
+		bucket.Size = buf.location
+		buf.global.bucketMap[node.Name] = bucket
+		*buf = *buf.global
+	}
+}
+
+// Procedure checkCall verifies that a call names a declared procedure with the right number of arguments
+func checkCall(buf *buffer, node *types.TreeNode) {
+	bucket, ok := buf.procLookup(node.Name)
+	if !ok {
+		buf.typeError(node, "AnalyzeProcUndefinedError", node.Name)
+		return
+	}
+	if len(bucket.Params) != len(node.Children) {
+		buf.typeError(node, "AnalyzeProcArgumentsError", node.Name)
+	}
+}
+
 func nullProc(buf *buffer, node *types.TreeNode) {
 	return
 }
@@ -100,42 +292,126 @@ func checkNode(buf *buffer, node *types.TreeNode) {
 	switch node.Node {
 	case types.ExpK:
 		if node.Exp == types.OpK {
-			if node.Children[0].Type != types.Integer || node.Children[1].Type != types.Integer {
-				typeError(node.Lineno, locale.Locale.AnalyzeTypeOpError)
-			}
-			if node.Op == types.EQ || node.Op == types.LT {
+			switch node.Op {
+			case types.AND, types.OR, types.NOT:
+				for index := 0; index < len(node.Children); index++ {
+					if node.Children[index].Type != types.Boolean {
+						buf.typeError(node.Children[index], "AnalyzeTypeLogicError")
+						break
+					}
+				}
 				node.Type = types.Boolean
-			} else {
-				node.Type = types.Integer
+			case types.PLUS:
+				// Adding anything to a string concatenates, numbers are converted to text
+				left, right := node.Children[0].Type, node.Children[1].Type
+				if (!isNumeric(left) && left != types.String) || (!isNumeric(right) && right != types.String) {
+					buf.typeError(node, "AnalyzeTypeOpError")
+				}
+				if left == types.String || right == types.String {
+					node.Type = types.String
+				} else {
+					node.Type = numericType(left, right)
+				}
+			case types.EQ, types.NE:
+				left, right := node.Children[0].Type, node.Children[1].Type
+				if !(isNumeric(left) && isNumeric(right)) && (left != right || (left != types.String && left != types.Boolean)) {
+					buf.typeError(node, "AnalyzeTypeOpError")
+				}
+				node.Type = types.Boolean
+			default:
+				left, right := node.Children[0].Type, node.Children[1].Type
+				if !isNumeric(left) || !isNumeric(right) {
+					buf.typeError(node, "AnalyzeTypeOpError")
+				}
+				switch node.Op {
+				case types.LT, types.GT, types.LE, types.GE:
+					node.Type = types.Boolean
+				default:
+					node.Type = numericType(left, right)
+				}
 			}
-		} else if node.Exp == types.ConstK || node.Exp == types.IdK {
+		} else if node.Exp == types.ConstK || node.Exp == types.LengthK {
 			node.Type = types.Integer
+		} else if node.Exp == types.IdK {
+			node.Type = buf.varType(node.Name)
+		} else if node.Exp == types.IndexK {
+			checkIndex(buf, node.Children[0])
+			node.Type = buf.varType(node.Name)
 		} else if node.Exp == types.StringK {
 			node.Type = types.String
+		} else if node.Exp == types.BoolK {
+			node.Type = types.Boolean
+		} else if node.Exp == types.RealK {
+			node.Type = types.Real
+		} else if node.Exp == types.CallExpK {
+			checkArgs(buf, node)
+			node.Type = types.Integer
 		}
 	case types.StmtK:
 		switch node.Stmt {
 		case types.IfK:
-			if node.Children[0].Type == types.Integer {
-				typeError(node.Lineno, locale.Locale.AnalyzeTypeIfError)
+			if node.Children[0].Type != types.Boolean {
+				buf.typeError(node.Children[0], "AnalyzeTypeIfError")
 			}
 		case types.AssignK:
-			if node.Children[0].Type != types.Integer {
-				typeError(node.Lineno, locale.Locale.AnalyzeTypeAssignError)
+			buf.assignType(node, node.Children[0].Type)
+			if len(node.Children) > 1 {
+				checkIndex(buf, node.Children[1])
+			}
+		case types.ReadK:
+			if len(node.Children) > 0 {
+				checkIndex(buf, node.Children[0])
+			}
+			// Code generation reads text or a number depending on the variable
+			node.Type = buf.varType(node.Name)
+			if node.Type == types.Boolean {
+				buf.typeError(node, "AnalyzeTypeReadError")
 			}
 		case types.WriteK:
-			if node.Children[0].Type != types.Integer && node.Children[0].Type != types.String {
-				typeError(node.Lineno, locale.Locale.AnalyzeTypeWriteError)
+			expType := node.Children[0].Type
+			if !isNumeric(expType) && expType != types.String && expType != types.Boolean {
+				buf.typeError(node.Children[0], "AnalyzeTypeWriteError")
 			}
 		case types.RepeatK:
-			if node.Children[0].Type == types.Integer {
-				typeError(node.Lineno, locale.Locale.AnalyzeTypeRepeatError)
+			if node.Children[1].Type != types.Boolean {
+				buf.typeError(node.Children[1], "AnalyzeTypeRepeatError")
 			}
+		case types.CallK:
+			checkArgs(buf, node)
+		case types.ReturnK:
+			if len(node.Children) > 0 && node.Children[0].Type != types.Integer {
+				buf.typeError(node.Children[0], "AnalyzeTypeReturnError")
+			}
+		case types.WhileK:
+			if node.Children[0].Type != types.Boolean {
+				buf.typeError(node.Children[0], "AnalyzeTypeWhileError")
+			}
+		case types.ProcK:
+			*buf = *buf.global
+		}
+	}
+}
+
+func checkIndex(buf *buffer, index *types.TreeNode) {
+	if index.Type != types.Integer {
+		buf.typeError(index, "AnalyzeTypeIndexError")
+	}
+}
+
+func checkArgs(buf *buffer, node *types.TreeNode) {
+	for index := 0; index < len(node.Children); index++ {
+		if node.Children[index].Type != types.Integer {
+			buf.typeError(node.Children[index], "AnalyzeTypeArgumentError")
+			break
 		}
 	}
 }
 
 func transverse(buf *buffer, node *types.TreeNode, preProc procNode, postProc procNode) {
+	if node == nil {
+		return
+	}
+
 	preProc(buf, node)
 	for index := 0; index < len(node.Children); index++ {
 		transverse(buf, node.Children[index], preProc, postProc)
@@ -147,13 +423,72 @@ func transverse(buf *buffer, node *types.TreeNode, preProc procNode, postProc pr
 
 }
 
-func BuildSymtab(node *types.TreeNode) map[string]types.Bucket {
-	buf := buffer{0, make(map[string]types.Bucket)}
-	buf.location = 0
-	transverse(&buf, node, insertNode, nullProc)
-	return buf.bucketMap
+func BuildSymtab(node *types.TreeNode) (map[string]types.Bucket, []types.Diagnostic) {
+	return ExtendSymtab(node, make(map[string]types.Bucket))
+}
+
+// Function ExtendSymtab adds symbols of a program fragment to an existing symbol table, new variables are allocated after the existing ones
+func ExtendSymtab(node *types.TreeNode, bucketMap map[string]types.Bucket) (map[string]types.Bucket, []types.Diagnostic) {
+	var diagnostics []types.Diagnostic
+	buf := buffer{0, bucketMap, nil, &diagnostics, false}
+	for _, bucket := range bucketMap {
+		if bucket.Kind != types.ProcSym && bucket.MemLoc+bucket.Size > buf.location {
+			buf.location = bucket.MemLoc + bucket.Size
+		}
+	}
+
+	// Declare procedures up front so they can be called before their declaration
+	for proc := node; proc != nil; proc = proc.Sibling {
+		if proc.Node == types.StmtK && proc.Stmt == types.ProcK {
+			buf.declareProc(proc)
+		}
+	}
+
+	transverse(&buf, node, insertNode, leaveNode)
+	return buf.bucketMap, diagnostics
 }
 
-func TypeCheck(node *types.TreeNode) {
-	transverse(nil, node, nullProc, checkNode)
+// Function copyScopes returns a copy of a symbol table that shares no scope with it
+func copyScopes(bucketMap map[string]types.Bucket) map[string]types.Bucket {
+	copied := make(map[string]types.Bucket, len(bucketMap))
+	for name, bucket := range bucketMap {
+		if bucket.Scope != nil {
+			bucket.Scope = copyScopes(bucket.Scope)
+		}
+		copied[name] = bucket
+	}
+
+	return copied
+}
+
+// Procedure seedTypes gives the variables without a type the type they got in the probed copy of the symbol table
+func seedTypes(bucketMap map[string]types.Bucket, probed map[string]types.Bucket) {
+	for name, bucket := range bucketMap {
+		if bucket.Kind == types.ProcSym {
+			seedTypes(bucket.Scope, probed[name].Scope)
+		} else if bucket.Type == types.Void {
+			bucket.Type = probed[name].Type
+			bucketMap[name] = bucket
+		}
+	}
+}
+
+/*
+Function TypeCheck checks the types of a program and decides the types of its variables.
+A variable takes the type of its first assignment even where it is read or used before that assignment, so read s; s := s + "!" reads text.
+A first pass on a copy of the symbol table learns those types, the second one checks the program against them.
+The interactive mode checks every fragment on its own, so there a later fragment cannot change a type.
+*/
+func TypeCheck(node *types.TreeNode, bucketMap map[string]types.Bucket) []types.Diagnostic {
+	var ignored []types.Diagnostic
+	probed := copyScopes(bucketMap)
+	probe := buffer{0, probed, nil, &ignored, true}
+	transverse(&probe, node, enterNode, checkNode)
+	seedTypes(bucketMap, probed)
+
+	var diagnostics []types.Diagnostic
+	buf := buffer{0, bucketMap, nil, &diagnostics, false}
+	transverse(&buf, node, enterNode, checkNode)
+
+	return diagnostics
 }
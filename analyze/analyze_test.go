@@ -0,0 +1,95 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package analyze
+
+import (
+	"github.com/ivandejanovic/mlpl/lexer"
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/parse"
+	"github.com/ivandejanovic/mlpl/types"
+	"strings"
+	"testing"
+)
+
+// Function check builds the symbol table of English source and checks its types
+func check(t *testing.T, source string) (map[string]types.Bucket, []types.Diagnostic) {
+	loc := locale.New()
+	tokens, diagnostics := parse.ParseReader(strings.NewReader(source), loc)
+	treeNode, lexDiagnostics := lexer.Lex(tokens, loc)
+	diagnostics = append(diagnostics, lexDiagnostics...)
+	bucketMap, symtabDiagnostics := BuildSymtab(treeNode)
+	diagnostics = append(diagnostics, symtabDiagnostics...)
+	if len(diagnostics) > 0 {
+		t.Fatalf("%q: reported %v", source, diagnostics)
+	}
+
+	return bucketMap, TypeCheck(treeNode, bucketMap)
+}
+
+func TestReadTypes(t *testing.T) {
+	tests := []struct {
+		source string
+		want   types.ExpType
+	}{
+		{"read s;\nwrite s * 2;", types.Integer},
+		{"read s;\nwrite \"Hello \" + s;\ns := \"\";", types.String},
+		{"read s;\ns := s + \"!\";\nwrite s;", types.String},
+		{"s := \"\";\nread s;", types.String},
+		{"read s;\nwrite s + 1;\ns := 2.5;", types.Real},
+	}
+
+	for _, test := range tests {
+		bucketMap, diagnostics := check(t, test.source)
+		if len(diagnostics) > 0 {
+			t.Errorf("%q: TypeCheck reported %v", test.source, diagnostics)
+		}
+		if got := bucketMap["s"].Type; got != test.want {
+			t.Errorf("%q: s holds type %v, want %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestReadTypesInProcedure(t *testing.T) {
+	bucketMap, diagnostics := check(t, "procedure p()\n  read s;\n  write s + \"!\";\n  s := \"\";\nend\np();")
+	if len(diagnostics) > 0 {
+		t.Errorf("TypeCheck reported %v", diagnostics)
+	}
+	if got := bucketMap["p"].Scope["s"].Type; got != types.String {
+		t.Errorf("s holds type %v, want %v", got, types.String)
+	}
+}
+
+func TestTypeMismatch(t *testing.T) {
+	tests := []string{
+		"read s;\ns := 1;\ns := \"a\";",
+		"b := true;\nread b;",
+	}
+
+	for _, source := range tests {
+		if _, diagnostics := check(t, source); len(diagnostics) != 1 {
+			t.Errorf("%q: TypeCheck reported %v, want one error", source, diagnostics)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package bytecode reads and writes compiled programs that run without their source and locale configuration
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/ivandejanovic/mlpl/codegen"
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/tm"
+	"github.com/ivandejanovic/mlpl/types"
+	"io"
+	"math"
+	"sort"
+)
+
+// Version of the file layout, files of another version are not run
+const Version = 1
+
+// Extension is the usual extension of bytecode files
+const Extension = ".mlc"
+
+const magic = "MLPC"
+
+// Program is the content of a bytecode file
+type Program struct {
+	Code   []tm.Instruction
+	Locale *locale.LocaleType
+	Lines  map[int]codegen.Statement // Line table the disassembler annotates the code with
+}
+
+// Function Is tells whether data starts like a bytecode file
+func Is(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// Procedure putString appends a string with its length as a varint in front
+func putString(buf *bytes.Buffer, s string) {
+	putUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+// Procedure putUvarint appends an unsigned varint
+func putUvarint(buf *bytes.Buffer, value uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], value)])
+}
+
+// Function readInt reads an unsigned varint that fits an int
+func readInt(r *bytes.Reader) (int, bool) {
+	value, err := binary.ReadUvarint(r)
+	if err != nil || value > math.MaxInt32 {
+		return 0, false
+	}
+
+	return int(value), true
+}
+
+// Function readString reads a string written by putString
+func readString(r *bytes.Reader) (string, bool) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil || length > uint64(r.Len()) {
+		return "", false
+	}
+
+	s := make([]byte, length)
+	r.Read(s)
+
+	return string(s), true
+}
+
+/*
+Procedure Write writes a compiled program with the runtime messages and number format of its locale.
+
+	the four bytes MLPC
+	version as a varint
+	locale name and runtime configuration, each as a varint length and bytes
+	line table size, then location, line and procedure name of every entry in location order
+	the program as written by tm.Encode, with its string table
+*/
+func Write(w io.Writer, program *Program) error {
+	var buf bytes.Buffer
+
+	buf.WriteString(magic)
+	putUvarint(&buf, Version)
+	putString(&buf, program.Locale.Name)
+	putString(&buf, string(program.Locale.RuntimeConfig()))
+
+	locs := make([]int, 0, len(program.Lines))
+	for loc := range program.Lines {
+		locs = append(locs, loc)
+	}
+	sort.Ints(locs)
+	putUvarint(&buf, uint64(len(locs)))
+	for _, loc := range locs {
+		putUvarint(&buf, uint64(loc))
+		putUvarint(&buf, uint64(program.Lines[loc].Line))
+		putString(&buf, program.Lines[loc].Proc)
+	}
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	return tm.Encode(w, program.Code)
+}
+
+/*
+Function Load reads a bytecode file into a program and the locale it was compiled in.
+Keys the file does not carry keep their English values.
+*/
+func Load(file string, data []byte) (*Program, []types.Diagnostic) {
+	invalid := []types.Diagnostic{{File: file, Severity: types.ErrorSeverity, Key: "BytecodeFormatError", Args: []interface{}{file}}}
+	if !Is(data) {
+		return nil, invalid
+	}
+
+	r := bytes.NewReader(data[len(magic):])
+	version, ok := readInt(r)
+	if !ok {
+		return nil, invalid
+	}
+	if version != Version {
+		return nil, []types.Diagnostic{{File: file, Severity: types.ErrorSeverity, Key: "BytecodeVersionError", Args: []interface{}{file, version, Version}}}
+	}
+
+	name, ok := readString(r)
+	if !ok {
+		return nil, invalid
+	}
+	config, ok := readString(r)
+	if !ok {
+		return nil, invalid
+	}
+
+	loc := locale.New()
+	if diagnostics := loc.Load(file, []byte(config)); len(diagnostics) > 0 {
+		return nil, invalid
+	}
+	loc.Name = name
+
+	count, ok := readInt(r)
+	if !ok {
+		return nil, invalid
+	}
+	lines := make(map[int]codegen.Statement)
+	for index := 0; index < count; index++ {
+		var statement codegen.Statement
+		location, ok := readInt(r)
+		if ok {
+			statement.Line, ok = readInt(r)
+		}
+		if ok {
+			statement.Proc, ok = readString(r)
+		}
+		if !ok {
+			return nil, invalid
+		}
+		lines[location] = statement
+	}
+
+	code, err := tm.Decode(r)
+	if err != nil {
+		return nil, invalid
+	}
+
+	return &Program{code, loc, lines}, nil
+}
@@ -0,0 +1,109 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package bytecode
+
+import (
+	"bytes"
+	"github.com/ivandejanovic/mlpl/codegen"
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/tm"
+	"reflect"
+	"testing"
+)
+
+// Function serbian returns the bundled Serbian locale
+func serbian(t *testing.T) *locale.LocaleType {
+	config, ok := locale.BundledConfig("serbian")
+	if !ok {
+		t.Fatal("serbian locale is not bundled")
+	}
+	loc := locale.New()
+	if diagnostics := loc.Load("serbian.cfg", config); len(diagnostics) > 0 {
+		t.Fatalf("Load reported %v", diagnostics)
+	}
+
+	return loc
+}
+
+func TestWriteLoad(t *testing.T) {
+	program := &Program{
+		Code: []tm.Instruction{
+			{Loc: 0, Op: tm.LD, Arg1: 6},
+			{Loc: 1, Op: tm.PRINT, Str: "two\nlines"},
+			{Loc: 2, Op: tm.LDCF, Arg1: 0, Real: 1.5},
+			{Loc: 3, Op: tm.OUTF},
+			{Loc: 4, Op: tm.HALT},
+		},
+		Locale: serbian(t),
+		Lines:  map[int]codegen.Statement{1: {Line: 2}, 2: {Line: 3, Proc: "p"}},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, program); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !Is(buf.Bytes()) {
+		t.Error("Is does not recognize a written program")
+	}
+
+	loaded, diagnostics := Load("test.mlc", buf.Bytes())
+	if diagnostics != nil {
+		t.Fatalf("Load reported %v", diagnostics)
+	}
+	if !reflect.DeepEqual(loaded.Code, program.Code) {
+		t.Errorf("Load returned code\n%v\nwant\n%v", loaded.Code, program.Code)
+	}
+	if !reflect.DeepEqual(loaded.Lines, program.Lines) {
+		t.Errorf("Load returned line table %v, want %v", loaded.Lines, program.Lines)
+	}
+	if loaded.Locale.Name != program.Locale.Name || loaded.Locale.DecimalSeparator != "," || loaded.Locale.VmDivisionWIthZeroError != program.Locale.VmDivisionWIthZeroError {
+		t.Errorf("Load did not restore the runtime configuration of the %s locale", program.Locale.Name)
+	}
+}
+
+func TestLoadMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	Write(&buf, &Program{Code: []tm.Instruction{{Op: tm.HALT}}, Locale: locale.New()})
+	data := buf.Bytes()
+
+	version := append([]byte(magic), Version+1)
+	version = append(version, data[len(magic)+1:]...)
+
+	tests := []struct {
+		name string
+		data []byte
+		key  string
+	}{
+		{"not bytecode", []byte("write 1;"), "BytecodeFormatError"},
+		{"truncated", data[:len(data)-1], "BytecodeFormatError"},
+		{"other version", version, "BytecodeVersionError"},
+	}
+	for _, test := range tests {
+		program, diagnostics := Load("test.mlc", test.data)
+		if program != nil || len(diagnostics) != 1 || diagnostics[0].Key != test.key {
+			t.Errorf("%s: Load returned %v, want %s", test.name, diagnostics, test.key)
+		}
+	}
+}
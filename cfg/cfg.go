@@ -25,38 +25,265 @@ SOFTWARE.
 package cfg
 
 import (
-	"encoding/json"
+	"bufio"
 	"fmt"
 	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/types"
+	"github.com/ivandejanovic/mlpl/vm"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	minus       = "-"
 	doubleMinus = "--"
 	empty       = ""
-	usage       = "Usage: mlpl <codefilename> [configurationfilename]"
+	usage       = "Usage: mlpl [--lang <locale>] [--max-steps <count>] [--timeout <duration>] [--listing <file>] <codefilename> [configurationfilename]\n       mlpl repl [--lang <locale>] [--max-steps <count>] [--timeout <duration>] [configurationfilename]\n       mlpl build [--lang <locale>] [--listing <file>] [-o <bytecodefilename>] <codefilename> [configurationfilename]\n       mlpl run [--max-steps <count>] [--timeout <duration>] <bytecodefilename>\n       mlpl asm [--max-steps <count>] [--timeout <duration>] <assemblyfilename> [configurationfilename]\n       mlpl disasm [--lang <locale>] [-o <file>] <codefilename or bytecodefilename> [configurationfilename]\n       mlpl debug [--lang <locale>] [--listing <file>] <codefilename> [configurationfilename]\n       mlpl translate --from <locale> --to <locale> <codefilename>\n       mlpl locale check [<locale> ...]\n       mlpl --list-locales"
 )
 
-func getLocaleFromConfig(configFile string) {
+// Commands given as the first argument instead of a code file. An empty command runs the code file.
+const (
+	RunFileCommand     = ""
+	ReplCommand        = "repl"
+	DebugCommand       = "debug"
+	BuildCommand       = "build"
+	RunCommand         = "run"
+	AsmCommand         = "asm"
+	DisasmCommand      = "disasm"
+	TranslateCommand   = "translate"
+	LocaleCheckCommand = "locale check"
+)
+
+// Arguments holds what the command line asks for once the locale is loaded
+type Arguments struct {
+	Command  string
+	CodeFile string
+	Target   *locale.LocaleType // Locale the translate command writes the code file in
+	Configs  []string           // Locales the locale check command validates, by name or configuration file
+	Limits   vm.Limits          // Limits of a program run from a code file or of each fragment in the repl
+	Listing  string             // File the Tiny Machine assembly of a compiled code file is written to, empty for none
+	Output   string             // File the build command writes bytecode to, empty for the code file with the .mlc extension, or disasm its listing, empty for standard output
+}
+
+func getLocaleFromConfig(configFile string) []types.Diagnostic {
+	return loadConfig(configFile, locale.Locale)
+}
+
+// Function loadConfig reads a configuration file into a locale and assembles its reserved words
+func loadConfig(configFile string, loc *locale.LocaleType) []types.Diagnostic {
 	config, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		panic(err)
+		return []types.Diagnostic{{File: configFile, Severity: types.ErrorSeverity, Key: "ConfigFileError", Args: []interface{}{configFile}}}
+	}
+
+	return loc.Load(configFile, config)
+}
+
+// Function readPragma returns the locale named on the first line of a code file as #! locale: name #, empty when there is none
+func readPragma(codeFile string) string {
+	file, err := os.Open(codeFile)
+	if err != nil {
+		return empty
+	}
+	defer file.Close()
+
+	line, _ := bufio.NewReader(file).ReadString('\n')
+
+	return locale.PragmaName(line)
+}
+
+// Function environmentLocale returns the language code of LC_ALL or LANG, empty when neither is set
+func environmentLocale() string {
+	for _, variable := range []string{"LC_ALL", "LANG"} {
+		value := os.Getenv(variable)
+		if value == empty {
+			continue
+		}
+
+		// Values look like sr_RS.UTF-8, only the language code matters
+		if end := strings.IndexAny(value, "_.@"); end >= 0 {
+			value = value[:end]
+		}
+
+		return value
+	}
+
+	return empty
+}
+
+// Function loadNamed loads a locale given by name or language code and reports a missing one against file and line
+func loadNamed(name string, loc *locale.LocaleType, file string, line int) []types.Diagnostic {
+	configFile, config, found := locale.Find(name)
+	if !found {
+		return []types.Diagnostic{{File: file, Line: line, Severity: types.ErrorSeverity, Key: "LocaleNotFoundError", Args: []interface{}{name}}}
+	}
+
+	return loc.Load(configFile, config)
+}
+
+// Function ReadConfig returns the configuration of a locale given either as a configuration file or by name, a path to an existing file is taken as a file
+func ReadConfig(nameOrFile string) (string, []byte, []types.Diagnostic) {
+	if info, err := os.Stat(nameOrFile); err == nil && !info.IsDir() {
+		config, err := ioutil.ReadFile(nameOrFile)
+		if err != nil {
+			return nameOrFile, nil, []types.Diagnostic{{File: nameOrFile, Severity: types.ErrorSeverity, Key: "ConfigFileError", Args: []interface{}{nameOrFile}}}
+		}
+		return nameOrFile, config, nil
+	}
+
+	configFile, config, found := locale.Find(nameOrFile)
+	if !found {
+		return nameOrFile, nil, []types.Diagnostic{{Severity: types.ErrorSeverity, Key: "LocaleNotFoundError", Args: []interface{}{nameOrFile}}}
+	}
+
+	return configFile, config, nil
+}
+
+/*
+Function setLimit reads the value of the --max-steps or --timeout option into limits.
+A timeout is a duration like 500ms or 2m, a plain number counts seconds.
+*/
+func setLimit(flag string, value string, limits *vm.Limits) []types.Diagnostic {
+	invalid := []types.Diagnostic{{Severity: types.ErrorSeverity, Key: "ArgumentValueError", Args: []interface{}{value, doubleMinus + flag}}}
+
+	if flag == "max-steps" {
+		steps, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || steps <= 0 {
+			return invalid
+		}
+		limits.MaxSteps = steps
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if seconds, numErr := strconv.ParseFloat(value, 64); numErr == nil {
+		timeout, err = time.Duration(seconds*float64(time.Second)), nil
+	}
+	if err != nil || timeout <= 0 {
+		return invalid
+	}
+	limits.Timeout = timeout
+
+	return nil
+}
+
+/*
+Function detectLocale loads the locale a code file asks for with its pragma, or else the one of the environment.
+English is used when neither names a locale that can be found, but a pragma naming a missing locale is an error.
+*/
+func detectLocale(codeFile string) []types.Diagnostic {
+	if name := readPragma(codeFile); name != empty {
+		return loadNamed(name, locale.Locale, codeFile, 1)
+	}
+
+	if name := environmentLocale(); name != empty {
+		if configFile, config, found := locale.Find(name); found {
+			return locale.Locale.Load(configFile, config)
+		}
 	}
 
-	json.Unmarshal(config, locale.Locale)
-	locale.AssembleReserved()
+	return locale.AssembleReserved()
 }
 
-func HandleArgs() (bool, string) {
+// Function loadLocale loads a locale given either as a configuration file or by name
+func loadLocale(nameOrFile string, loc *locale.LocaleType) []types.Diagnostic {
+	configFile, config, diagnostics := ReadConfig(nameOrFile)
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	return loc.Load(configFile, config)
+}
+
+// Procedure listLocales prints the bundled locales with their language codes and keywords
+func listLocales() {
+	for _, name := range locale.Bundled() {
+		loc := locale.New()
+		loadNamed(name, loc, empty, 0)
+
+		if code := locale.Code(name); code != empty {
+			name += " (" + code + ")"
+		}
+		keywords := make([]string, 0, len(loc.ReservedArray))
+		for _, aliases := range loc.ReservedArray {
+			keywords = append(keywords, strings.Join(aliases, "/"))
+		}
+		fmt.Printf("%s: %s\n", name, strings.Join(keywords, " "))
+	}
+}
+
+/*
+Function handleTranslate reads the arguments of the translate command, the source locale becomes the current one.
+It returns true when the arguments are unusable.
+*/
+func handleTranslate(args []string, arguments *Arguments) (bool, []types.Diagnostic) {
+	var from, to string
+
+	for index := 0; index < len(args); index++ {
+		switch {
+		case (args[index] == "--from" || args[index] == "--to") && index+1 < len(args):
+			if args[index] == "--from" {
+				from = args[index+1]
+			} else {
+				to = args[index+1]
+			}
+			index++
+		case !strings.HasPrefix(args[index], minus) && arguments.CodeFile == empty:
+			arguments.CodeFile = args[index]
+		default:
+			fmt.Println(usage)
+			return true, nil
+		}
+	}
+
+	if from == empty || to == empty || arguments.CodeFile == empty {
+		fmt.Println(usage)
+		return true, nil
+	}
+
+	if diagnostics := loadLocale(from, locale.Locale); len(diagnostics) > 0 {
+		return true, diagnostics
+	}
+
+	arguments.Target = locale.New()
+	if diagnostics := loadLocale(to, arguments.Target); len(diagnostics) > 0 {
+		return true, diagnostics
+	}
+
+	return false, nil
+}
+
+func HandleArgs() (bool, Arguments, []types.Diagnostic) {
 	var abort bool = true
-	var codeFile string
+	var arguments Arguments = Arguments{Command: RunFileCommand}
+	var diagnostics []types.Diagnostic
 
 	args := os.Args[1:]
 	argc := len(args)
 
+	// Translate has options of its own
+	if argc > 0 && args[0] == TranslateCommand {
+		arguments.Command = TranslateCommand
+		abort, diagnostics = handleTranslate(args[1:], &arguments)
+		return abort, arguments, diagnostics
+	}
+
+	// Locale check takes any number of locales and checks the bundled ones when given none
+	if argc > 1 && args[0]+" "+args[1] == LocaleCheckCommand {
+		arguments.Command = LocaleCheckCommand
+		arguments.Configs = args[2:]
+		if len(arguments.Configs) == 0 {
+			arguments.Configs = locale.Bundled()
+		}
+		return false, arguments, detectLocale(empty)
+	}
+
+	var lang string = empty
+	var positional []string
+
 	for index := 0; index < argc; index++ {
 		var flag string = empty
 		var flagArg string = args[index]
@@ -67,38 +294,107 @@ func HandleArgs() (bool, string) {
 			flag = strings.TrimPrefix(flagArg, minus)
 		}
 
-		if flag != empty {
-			switch flag {
-			case "h", "help":
-				fmt.Println()
-				fmt.Println(usage)
-				fmt.Println()
-				fmt.Println("Options:")
-				fmt.Println("  -h, --help       Prints help")
-				fmt.Println("  -v, --version    Prints version")
-			case "v", "version":
-				fmt.Println("MLPL interpreter version 1.1.1")
-			default:
-				fmt.Println("Invalid usage. For correct usage examples please try: mlpl -h")
+		if flag == empty {
+			positional = append(positional, flagArg)
+			continue
+		}
+
+		if flag == "lang" && index+1 < argc {
+			lang = args[index+1]
+			index++
+			continue
+		}
+
+		if (flag == "o" || flag == "output") && index+1 < argc {
+			arguments.Output = args[index+1]
+			index++
+			continue
+		}
+
+		if flag == "listing" && index+1 < argc {
+			arguments.Listing = args[index+1]
+			index++
+			continue
+		}
+
+		if (flag == "max-steps" || flag == "timeout") && index+1 < argc {
+			diagnostics = setLimit(flag, args[index+1], &arguments.Limits)
+			if len(diagnostics) > 0 {
+				return abort, arguments, diagnostics
 			}
-			return abort, codeFile
+			index++
+			continue
+		}
+
+		switch flag {
+		case "h", "help":
+			fmt.Println()
+			fmt.Println(usage)
+			fmt.Println()
+			fmt.Println("Options:")
+			fmt.Println("  -h, --help            Prints help")
+			fmt.Println("  -v, --version         Prints version")
+			fmt.Println("  --lang <locale>       Runs with a locale given by name or language code, like serbian or sr")
+			fmt.Println("  --list-locales        Prints the built in locales with their keywords")
+			fmt.Println("  --max-steps <count>   Stops a program after it executes that many instructions")
+			fmt.Println("  --timeout <duration>  Stops a program after it runs for that long, like 500ms, 10s or 2m")
+			fmt.Println("  --listing <file>      Writes the Tiny Machine assembly of the compiled program to a file")
+			fmt.Println("  -o, --output <file>   File build writes bytecode to, by default the code file with the .mlc extension, or disasm its listing to")
+			fmt.Println("  --from <locale>       Locale, by name or configuration file, a translated code file is written in")
+			fmt.Println("  --to <locale>         Locale, by name or configuration file, a code file is translated to")
+		case "v", "version":
+			fmt.Println("MLPL interpreter version 1.1.1")
+		case "list-locales":
+			listLocales()
+		default:
+			fmt.Println("Invalid usage. For correct usage examples please try: mlpl -h")
 		}
+		return abort, arguments, diagnostics
+	}
+
+	args = positional
+	argc = len(args)
+
+	// A command may come first, every command except repl takes a code file
+	if argc > 0 && (args[0] == ReplCommand || args[0] == DebugCommand || args[0] == BuildCommand || args[0] == RunCommand ||
+		args[0] == AsmCommand || args[0] == DisasmCommand) {
+		arguments.Command = args[0]
+		args = args[1:]
+		argc--
+	}
+
+	files := 1
+	if arguments.Command == ReplCommand {
+		files = 0
 	}
 
-	if argc < 1 || argc > 2 {
+	if argc < files || argc > files+1 {
 		fmt.Println(usage)
-		return abort, codeFile
+		return abort, arguments, diagnostics
 	}
 
-	if argc == 2 {
-		getLocaleFromConfig(args[1])
+	// A locale given on the command line wins over the pragma and the environment
+	if argc == files+1 && lang != empty {
+		fmt.Println(usage)
+		return abort, arguments, diagnostics
+	} else if argc == files+1 {
+		diagnostics = getLocaleFromConfig(args[files])
+	} else if lang != empty {
+		diagnostics = loadNamed(lang, locale.Locale, empty, 0)
+	} else if files == 1 {
+		diagnostics = detectLocale(args[0])
 	} else {
-		locale.AssembleReserved()
+		diagnostics = detectLocale(empty)
+	}
+	if len(diagnostics) > 0 {
+		return abort, arguments, diagnostics
 	}
 
 	//If we get this far we have good data to process
 	abort = false
-	codeFile = args[0]
+	if files == 1 {
+		arguments.CodeFile = args[0]
+	}
 
-	return abort, codeFile
+	return abort, arguments, diagnostics
 }
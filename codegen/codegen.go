@@ -25,9 +25,8 @@ SOFTWARE.
 package codegen
 
 import (
-	"errors"
-	"fmt"
 	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/tm"
 	"github.com/ivandejanovic/mlpl/types"
 )
 
@@ -35,15 +34,63 @@ const (
 	pc  int = 7 // pc = program counter
 	mp  int = 6 // mp = "memory pointer" point to top of memory (for temp storage)
 	gp  int = 5 // gp = "global pointer" points to bottom of memory for (global) variable storage
+	fp  int = 4 // fp = "frame pointer" points to the frame of the currently executing procedure
+	sl  int = 3 // sl = "stack limit" lowest address mp may reach, set by every fragment above its global storage
 	ac  int = 0 // accumulator
 	ac1 int = 1 // 2nd accumulator
 )
 
+/*
+A procedure frame is laid out downwards from fp
+
+	0(fp) = return address
+	-1(fp) = saved frame pointer of the caller
+	-2(fp) = saved memory pointer of the caller
+	-3(fp) and below = parameters followed by local variables
+
+mp is moved below the frame so temps of the procedure do not overwrite it.
+*/
+const frameHeader int = 3
+
+type callSite struct {
+	loc  int    // Location of the jump to be backpatched
+	name string // Name of the called procedure
+}
+
+// Statement is an entry of the line table, the source line and enclosing procedure of a statement whose code starts at a location
+type Statement struct {
+	Line int
+	Proc string // Empty in the main program
+}
+
 type codeBuffer struct {
-	code        []string
-	tmpOffset   int // tmpOffset is the memory offset for temps. It is decremented each time a temp is stored, and incremeted when loaded again.
-	emitLoc     int // TM location number for current instruction emission
-	highEmitLoc int // Highest TM location emitted so far. For use in conjunction with emitSkip, emitBackup, and emitRestore
+	code        []tm.Instruction
+	tmpOffset   int                     // tmpOffset is the memory offset for temps. It is decremented each time a temp is stored, and incremeted when loaded again.
+	emitLoc     int                     // TM location number for current instruction emission
+	highEmitLoc int                     // Highest TM location emitted so far. For use in conjunction with emitSkip, emitBackup, and emitRestore
+	scope       map[string]types.Bucket // Local variables of the procedure being generated, nil for the main program
+	procLoc     map[string]int          // Entry locations of procedures generated so far
+	calls       []callSite              // Calls to procedures not generated yet
+	proc        string                  // Name of the procedure being generated, empty for the main program
+	lines       map[int]Statement       // Line table of the statements generated so far
+	locale      *locale.LocaleType      // Locale of the true and false written by the program
+	diagnostics []types.Diagnostic
+}
+
+// Procedure codegenError records a tree the generator does not know how to translate
+func (codeBuf *codeBuffer) codegenError(treeNode *types.TreeNode, key string) {
+	diagnostic := types.Diagnostic{Line: treeNode.Lineno, Column: treeNode.Column, Severity: types.ErrorSeverity, Key: key}
+	codeBuf.diagnostics = append(codeBuf.diagnostics, diagnostic)
+}
+
+// Procedure emit places an instruction at the current location and moves on to the next one
+func (codeBuf *codeBuffer) emit(inst tm.Instruction) {
+	inst.Loc = codeBuf.emitLoc
+	codeBuf.emitLoc += 1
+	if codeBuf.highEmitLoc < codeBuf.emitLoc {
+		codeBuf.highEmitLoc = codeBuf.emitLoc
+	}
+	codeBuf.code = append(codeBuf.code, inst)
 }
 
 /*
@@ -52,13 +99,30 @@ Procedure emitSO emits a string-only TM instruction
 	op = the opcode
 	s = string
 */
-func (codeBuf *codeBuffer) emitSO(op string, s string) {
-	code := fmt.Sprintf("%3d: %5s %s", codeBuf.emitLoc, op, s)
-	codeBuf.emitLoc += 1
-	if codeBuf.highEmitLoc < codeBuf.emitLoc {
-		codeBuf.highEmitLoc = codeBuf.emitLoc
-	}
-	codeBuf.code = append(codeBuf.code, code)
+func (codeBuf *codeBuffer) emitSO(op tm.Opcode, s string) {
+	codeBuf.emit(tm.Instruction{Op: op, Str: s})
+}
+
+/*
+Procedure emitRS emits a register-string TM instruction
+
+	op = the opcode
+	r = target register
+	s = string
+*/
+func (codeBuf *codeBuffer) emitRS(op tm.Opcode, r int, s string) {
+	codeBuf.emit(tm.Instruction{Op: op, Arg1: r, Str: s})
+}
+
+/*
+Procedure emitRF emits a register-real TM instruction
+
+	op = the opcode
+	r = target register
+	f = real number
+*/
+func (codeBuf *codeBuffer) emitRF(op tm.Opcode, r int, f float64) {
+	codeBuf.emit(tm.Instruction{Op: op, Arg1: r, Real: f})
 }
 
 /*
@@ -69,13 +133,8 @@ Procedure emitRO emits a register-only TM instruction
 	s = 1st source register
 	t = 2nd source register
 */
-func (codeBuf *codeBuffer) emitRO(op string, r int, s int, t int) {
-	code := fmt.Sprintf("%3d: %5s %d, %d, %d", codeBuf.emitLoc, op, r, s, t)
-	codeBuf.emitLoc += 1
-	if codeBuf.highEmitLoc < codeBuf.emitLoc {
-		codeBuf.highEmitLoc = codeBuf.emitLoc
-	}
-	codeBuf.code = append(codeBuf.code, code)
+func (codeBuf *codeBuffer) emitRO(op tm.Opcode, r int, s int, t int) {
+	codeBuf.emit(tm.Instruction{Op: op, Arg1: r, Arg2: s, Arg3: t})
 }
 
 /*
@@ -86,13 +145,8 @@ Procedure emitRM emits a register-to-memory TM instruction
 	d = the offset
 	s = the base register
 */
-func (codeBuf *codeBuffer) emitRM(op string, r int, d int, s int) {
-	code := fmt.Sprintf("%3d: %5s %d, %d(%d)", codeBuf.emitLoc, op, r, d, s)
-	codeBuf.emitLoc += 1
-	if codeBuf.highEmitLoc < codeBuf.emitLoc {
-		codeBuf.highEmitLoc = codeBuf.emitLoc
-	}
-	codeBuf.code = append(codeBuf.code, code)
+func (codeBuf *codeBuffer) emitRM(op tm.Opcode, r int, d int, s int) {
+	codeBuf.emit(tm.Instruction{Op: op, Arg1: r, Arg2: d, Arg3: s})
 }
 
 // Function emitSkip skips "howMany" code locations for later backpatch. It also returns the current code position
@@ -123,14 +177,8 @@ Procedure emitRM_Abs converts an absolute reference to a pc-relative reference w
 	r = target register
 	a = the absolute location in memory
 */
-func (codeBuf *codeBuffer) emitRM_Abs(op string, r int, a int) {
-	abs := a - (codeBuf.emitLoc + 1)
-	code := fmt.Sprintf("%3d: %5s %d, %d(%d)", codeBuf.emitLoc, op, r, abs, pc)
-	codeBuf.emitLoc += 1
-	if codeBuf.highEmitLoc < codeBuf.emitLoc {
-		codeBuf.highEmitLoc = codeBuf.emitLoc
-	}
-	codeBuf.code = append(codeBuf.code, code)
+func (codeBuf *codeBuffer) emitRM_Abs(op tm.Opcode, r int, a int) {
+	codeBuf.emitRM(op, r, a-(codeBuf.emitLoc+1), pc)
 }
 
 func findLoc(bucketMap map[string]types.Bucket, name string) int {
@@ -143,11 +191,136 @@ func findLoc(bucketMap map[string]types.Bucket, name string) int {
 	return -1
 }
 
+// Function globalsSize returns the number of data memory cells taken by global variables and arrays
+func globalsSize(bucketMap map[string]types.Bucket) int {
+	size := 0
+	for _, bucket := range bucketMap {
+		if bucket.Kind != types.ProcSym && bucket.MemLoc+bucket.Size > size {
+			size = bucket.MemLoc + bucket.Size
+		}
+	}
+
+	return size
+}
+
+// Function lookup finds a variable or array in the current scope
+func (codeBuf *codeBuffer) lookup(bucketMap map[string]types.Bucket, name string) types.Bucket {
+	if codeBuf.scope != nil {
+		return codeBuf.scope[name]
+	}
+
+	return bucketMap[name]
+}
+
+// Function varLoc returns the offset and base register of a variable in the current scope. Arrays are addressed by their lowest cell.
+func (codeBuf *codeBuffer) varLoc(bucketMap map[string]types.Bucket, name string) (int, int) {
+	if codeBuf.scope != nil {
+		return Locate(codeBuf.scope[name], true)
+	}
+
+	return findLoc(bucketMap, name), gp
+}
+
+// Function Locate returns the offset and base register generated code uses for the lowest cell of a global or local variable
+func Locate(bucket types.Bucket, local bool) (int, int) {
+	if local {
+		return -(frameHeader + bucket.MemLoc + bucket.Size - 1), fp
+	}
+
+	return bucket.MemLoc, gp
+}
+
+// Function emitElementAddr turns the index in register r into the absolute address of the array element, checking its bounds first. It returns the offset to use with r.
+func (codeBuf *codeBuffer) emitElementAddr(bucketMap map[string]types.Bucket, name string, r int) int {
+	size := codeBuf.lookup(bucketMap, name).Size
+	loc, base := codeBuf.varLoc(bucketMap, name)
+
+	codeBuf.emitRM(tm.CHK, r, size, 0)
+	codeBuf.emitRO(tm.ADD, r, r, base)
+
+	return loc
+}
+
+// Procedure emitReturn restores the caller frame and jumps back to the return address
+func (codeBuf *codeBuffer) emitReturn() {
+	codeBuf.emitRM(tm.LD, ac1, 0, fp)
+	codeBuf.emitRM(tm.LD, mp, -2, fp)
+	codeBuf.emitRM(tm.LD, fp, -1, fp)
+	codeBuf.emitRM(tm.LDA, pc, 0, ac1)
+}
+
+// Procedure genCall generates code for a procedure call, leaving the return value in ac
+func genCall(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBuf *codeBuffer) {
+	base := codeBuf.tmpOffset
+
+	// Arguments are stored into the new frame right below its header
+	codeBuf.tmpOffset -= frameHeader
+	for index := 0; index < len(treeNode.Children); index++ {
+		cGen(treeNode.Children[index], bucketMap, codeBuf)
+		codeBuf.emitRM(tm.ST, ac, codeBuf.tmpOffset, mp)
+		codeBuf.tmpOffset -= 1
+	}
+	codeBuf.tmpOffset = base
+
+	codeBuf.emitRM(tm.ST, fp, base-1, mp)
+	codeBuf.emitRM(tm.ST, mp, base-2, mp)
+	codeBuf.emitRM(tm.LDA, fp, base, mp)
+	codeBuf.emitRM(tm.LDA, ac1, 2, pc)
+	codeBuf.emitRM(tm.ST, ac1, 0, fp)
+
+	loc, ok := codeBuf.procLoc[treeNode.Name]
+	if ok {
+		codeBuf.emitRM_Abs(tm.LDA, pc, loc)
+	} else {
+		loc = codeBuf.emitSkip(1)
+		codeBuf.calls = append(codeBuf.calls, callSite{loc, treeNode.Name})
+	}
+}
+
+// Procedure genProc generates the body of a procedure in place and jumps over it
+func genProc(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBuf *codeBuffer) {
+	bucket := bucketMap[treeNode.Name]
+	savedLoc := codeBuf.emitSkip(1)
+	savedTmpOffset := codeBuf.tmpOffset
+	codeBuf.procLoc[treeNode.Name] = codeBuf.emitSkip(0)
+	codeBuf.scope = bucket.Scope
+	codeBuf.proc = treeNode.Name
+	codeBuf.tmpOffset = 0
+
+	// Move mp below the frame, stopping when the frame and room for temps reach the globals, and clear local variables
+	codeBuf.emitRM(tm.LDA, mp, -(frameHeader + bucket.Size), fp)
+	codeBuf.emitRM(tm.STK, mp, 0, sl)
+	if bucket.Size > len(bucket.Params) {
+		codeBuf.emitRM(tm.LDC, ac, 0, 0)
+		for index := len(bucket.Params); index < bucket.Size; index++ {
+			codeBuf.emitRM(tm.ST, ac, -(frameHeader + index), fp)
+		}
+	}
+
+	// Generate code for body, falling off the end returns zero
+	cGen(treeNode.Children[len(treeNode.Children)-1], bucketMap, codeBuf)
+	codeBuf.emitRM(tm.LDC, ac, 0, 0)
+	codeBuf.emitReturn()
+
+	codeBuf.scope = nil
+	codeBuf.proc = ""
+	codeBuf.tmpOffset = savedTmpOffset
+	loc := codeBuf.emitSkip(0)
+	codeBuf.emitBackup(savedLoc)
+	codeBuf.emitRM_Abs(tm.LDA, pc, loc)
+	codeBuf.emitRestore()
+}
+
 // Procedure genStmt generates code at a statement node
 func genStmt(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBuf *codeBuffer) {
 	var p1, p2, p3 *types.TreeNode = nil, nil, nil
 	var savedLoc1, savedLoc2, loc int
 
+	// A statement sharing its first location with an enclosing one, like the body of repeat, is not entered again
+	if _, ok := codeBuf.lines[codeBuf.emitLoc]; !ok && treeNode.Stmt != types.ArrayK {
+		codeBuf.lines[codeBuf.emitLoc] = Statement{treeNode.Lineno, codeBuf.proc}
+	}
+
 	switch treeNode.Stmt {
 	case types.IfK:
 		p1 = treeNode.Children[0]
@@ -165,14 +338,14 @@ func genStmt(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBu
 		savedLoc2 = codeBuf.emitSkip(1)
 		loc = codeBuf.emitSkip(0)
 		codeBuf.emitBackup(savedLoc1)
-		codeBuf.emitRM_Abs("JEQ", ac, loc)
+		codeBuf.emitRM_Abs(tm.JEQ, ac, loc)
 		codeBuf.emitRestore()
 
 		// Recurse on else part
 		cGen(p3, bucketMap, codeBuf)
 		loc = codeBuf.emitSkip(0)
 		codeBuf.emitBackup(savedLoc2)
-		codeBuf.emitRM_Abs("LDA", pc, loc)
+		codeBuf.emitRM_Abs(tm.LDA, pc, loc)
 		codeBuf.emitRestore()
 	case types.RepeatK:
 		p1 = treeNode.Children[0]
@@ -184,31 +357,96 @@ func genStmt(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBu
 		// Generate code for test
 		cGen(p2, bucketMap, codeBuf)
 
-		codeBuf.emitRM_Abs("JEQ", ac, loc)
+		codeBuf.emitRM_Abs(tm.JEQ, ac, loc)
+	case types.WhileK:
+		p1 = treeNode.Children[0]
+		p2 = treeNode.Children[1]
+		savedLoc1 = codeBuf.emitSkip(0)
+
+		// Generate code for test
+		cGen(p1, bucketMap, codeBuf)
+		savedLoc2 = codeBuf.emitSkip(1)
+
+		// Generate code for body and jump back to test
+		cGen(p2, bucketMap, codeBuf)
+		codeBuf.emitRM_Abs(tm.LDA, pc, savedLoc1)
+
+		// Backpatch exit jump past the loop
+		loc = codeBuf.emitSkip(0)
+		codeBuf.emitBackup(savedLoc2)
+		codeBuf.emitRM_Abs(tm.JEQ, ac, loc)
+		codeBuf.emitRestore()
 	case types.AssignK:
 		// Generate code for rhs
 		p1 = treeNode.Children[0]
 		cGen(p1, bucketMap, codeBuf)
+		codeBuf.emitConvert(treeNode.Type, p1.Type)
+		if len(treeNode.Children) > 1 {
+			// Push rhs while the element address is computed
+			codeBuf.emitRM(tm.ST, ac, codeBuf.tmpOffset, mp)
+			codeBuf.tmpOffset -= 1
+			cGen(treeNode.Children[1], bucketMap, codeBuf)
+			codeBuf.emitRM(tm.LDA, ac1, 0, ac)
+			loc = codeBuf.emitElementAddr(bucketMap, treeNode.Name, ac1)
+			codeBuf.tmpOffset += 1
+			codeBuf.emitRM(tm.LD, ac, codeBuf.tmpOffset, mp)
+			codeBuf.emitRM(tm.ST, ac, loc, ac1)
+			break
+		}
 		// Now store value
-		loc = findLoc(bucketMap, treeNode.Name)
-		codeBuf.emitRM("ST", ac, loc, gp)
+		loc, base := codeBuf.varLoc(bucketMap, treeNode.Name)
+		codeBuf.emitRM(tm.ST, ac, loc, base)
 	case types.ReadK:
-		codeBuf.emitRO("IN", ac, 0, 0)
-		loc = findLoc(bucketMap, treeNode.Name)
-		codeBuf.emitRM("ST", ac, loc, gp)
+		if len(treeNode.Children) > 0 {
+			cGen(treeNode.Children[0], bucketMap, codeBuf)
+			codeBuf.emitRM(tm.LDA, ac1, 0, ac)
+			loc = codeBuf.emitElementAddr(bucketMap, treeNode.Name, ac1)
+			codeBuf.emitIn(treeNode.Type)
+			codeBuf.emitRM(tm.ST, ac, loc, ac1)
+			break
+		}
+		codeBuf.emitIn(treeNode.Type)
+		loc, base := codeBuf.varLoc(bucketMap, treeNode.Name)
+		codeBuf.emitRM(tm.ST, ac, loc, base)
+	case types.ArrayK:
+		// Arrays are allocated by the symbol table, nothing to generate
+	case types.ProcK:
+		genProc(treeNode, bucketMap, codeBuf)
+	case types.CallK:
+		genCall(treeNode, bucketMap, codeBuf)
+	case types.ReturnK:
+		if len(treeNode.Children) > 0 {
+			cGen(treeNode.Children[0], bucketMap, codeBuf)
+		} else {
+			codeBuf.emitRM(tm.LDC, ac, 0, 0)
+		}
+		codeBuf.emitReturn()
 	case types.WriteK:
 		//Get child
 		p1 = treeNode.Children[0]
-		//Check if we output string or id
-		if p1.Type == types.String {
+		//Check if we output string literal, string expression or integer expression
+		if p1.Exp == types.StringK {
 			//Generate print code
-			codeBuf.emitSO("PRINT", p1.ValString)
+			codeBuf.emitSO(tm.PRINT, p1.ValString)
+		} else if p1.Type == types.String {
+			cGen(p1, bucketMap, codeBuf)
+			codeBuf.emitRO(tm.OUTS, ac, 0, 0)
+		} else if p1.Type == types.Real {
+			cGen(p1, bucketMap, codeBuf)
+			codeBuf.emitRO(tm.OUTF, ac, 0, 0)
+		} else if p1.Type == types.Boolean {
+			// Print the localized true or false keyword
+			cGen(p1, bucketMap, codeBuf)
+			codeBuf.emitRM(tm.JEQ, ac, 2, pc)
+			codeBuf.emitSO(tm.PRINT, codeBuf.locale.Keyword(types.TRUE))
+			codeBuf.emitRM(tm.LDA, pc, 1, pc)
+			codeBuf.emitSO(tm.PRINT, codeBuf.locale.Keyword(types.FALSE))
 		} else {
 			// Generate code for expression to write
 			p1 = treeNode.Children[0]
 			cGen(p1, bucketMap, codeBuf)
 			// Now output it
-			codeBuf.emitRO("OUT", ac, 0, 0)
+			codeBuf.emitRO(tm.OUT, ac, 0, 0)
 		}
 	}
 }
@@ -216,55 +454,162 @@ func genStmt(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBu
 // Procedure genExp generates code at an expression node
 func genExp(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBuf *codeBuffer) {
 	var p1, p2 *types.TreeNode
-	var loc int
 
 	switch treeNode.Exp {
 	case types.ConstK:
 		// Gen code to load integer constant using LDC
-		codeBuf.emitRM("LDC", ac, treeNode.Val, 0)
+		codeBuf.emitRM(tm.LDC, ac, treeNode.Val, 0)
 	case types.IdK:
-		loc = findLoc(bucketMap, treeNode.Name)
-		codeBuf.emitRM("LD", ac, loc, gp)
+		loc, base := codeBuf.varLoc(bucketMap, treeNode.Name)
+		codeBuf.emitRM(tm.LD, ac, loc, base)
+	case types.IndexK:
+		cGen(treeNode.Children[0], bucketMap, codeBuf)
+		loc := codeBuf.emitElementAddr(bucketMap, treeNode.Name, ac)
+		codeBuf.emitRM(tm.LD, ac, loc, ac)
+	case types.StringK:
+		codeBuf.emitRS(tm.LDS, ac, treeNode.ValString)
+	case types.BoolK:
+		codeBuf.emitRM(tm.LDC, ac, treeNode.Val, 0)
+	case types.RealK:
+		codeBuf.emitRF(tm.LDCF, ac, treeNode.ValReal)
+	case types.LengthK:
+		codeBuf.emitRM(tm.LDC, ac, codeBuf.lookup(bucketMap, treeNode.Name).Size, 0)
+	case types.CallExpK:
+		genCall(treeNode, bucketMap, codeBuf)
 	case types.OpK:
+		switch treeNode.Op {
+		case types.AND, types.OR:
+			genLogic(treeNode, bucketMap, codeBuf)
+			return
+		case types.NOT:
+			cGen(treeNode.Children[0], bucketMap, codeBuf)
+			codeBuf.emitRM(tm.LDC, ac1, 1, 0)
+			codeBuf.emitRO(tm.SUB, ac, ac1, ac)
+			return
+		}
+
 		p1 = treeNode.Children[0]
 		p2 = treeNode.Children[1]
 		// Gen code for ac = left arg
 		cGen(p1, bucketMap, codeBuf)
+		codeBuf.emitConvert(operandType(treeNode), p1.Type)
 		// Gen code to push left operand
-		codeBuf.emitRM("ST", ac, codeBuf.tmpOffset, mp)
+		codeBuf.emitRM(tm.ST, ac, codeBuf.tmpOffset, mp)
 		codeBuf.tmpOffset -= 1
 		// Gen code for ac = right operand
 		cGen(p2, bucketMap, codeBuf)
+		codeBuf.emitConvert(operandType(treeNode), p2.Type)
 		// Now load left operand
 		codeBuf.tmpOffset += 1
-		codeBuf.emitRM("LD", ac1, codeBuf.tmpOffset, mp)
+		codeBuf.emitRM(tm.LD, ac1, codeBuf.tmpOffset, mp)
+		isReal := operandType(treeNode) == types.Real
 		switch treeNode.Op {
 		case types.PLUS:
-			codeBuf.emitRO("ADD", ac, ac1, ac)
+			if treeNode.Type == types.String {
+				codeBuf.emitRO(tm.CAT, ac, ac1, ac)
+			} else if isReal {
+				codeBuf.emitRO(tm.ADDF, ac, ac1, ac)
+			} else {
+				codeBuf.emitRO(tm.ADD, ac, ac1, ac)
+			}
 		case types.MINUS:
-			codeBuf.emitRO("SUB", ac, ac1, ac)
+			codeBuf.emitArith(tm.SUB, tm.SUBF, isReal)
 		case types.TIMES:
-			codeBuf.emitRO("MUL", ac, ac1, ac)
+			codeBuf.emitArith(tm.MUL, tm.MULF, isReal)
 		case types.OVER:
-			codeBuf.emitRO("DIV", ac, ac1, ac)
+			codeBuf.emitArith(tm.DIV, tm.DIVF, isReal)
 		case types.LT:
-			codeBuf.emitRO("SUB", ac, ac1, ac)
-			codeBuf.emitRM("JLT", ac, 2, pc)
-			codeBuf.emitRM("LDC", ac, 0, ac)
-			codeBuf.emitRM("LDA", pc, 1, pc)
-			codeBuf.emitRM("LDC", ac, 1, ac)
+			codeBuf.emitCompare(tm.JLT, isReal)
+		case types.GT:
+			codeBuf.emitCompare(tm.JGT, isReal)
+		case types.LE:
+			codeBuf.emitCompare(tm.JLE, isReal)
+		case types.GE:
+			codeBuf.emitCompare(tm.JGE, isReal)
 		case types.EQ:
-			codeBuf.emitRO("SUB", ac, ac1, ac)
-			codeBuf.emitRM("JEQ", ac, 2, pc)
-			codeBuf.emitRM("LDC", ac, 0, ac)
-			codeBuf.emitRM("LDA", pc, 1, pc)
-			codeBuf.emitRM("LDC", ac, 1, ac)
+			codeBuf.emitCompare(tm.JEQ, isReal)
+		case types.NE:
+			codeBuf.emitCompare(tm.JNE, isReal)
 		default:
-			panic(errors.New(locale.Locale.CodegenUnknownOperatorError))
+			codeBuf.codegenError(treeNode, "CodegenUnknownOperatorError")
 		}
 	}
 }
 
+// Function operandType returns the type both operands of a binary operation are converted to
+func operandType(treeNode *types.TreeNode) types.ExpType {
+	left, right := treeNode.Children[0].Type, treeNode.Children[1].Type
+
+	if treeNode.Type == types.String {
+		return types.String
+	}
+	if left == types.Real || right == types.Real {
+		return types.Real
+	}
+
+	return left
+}
+
+// Procedure emitConvert converts the value in ac from one type to another. Numbers become text for strings and integers become reals.
+func (codeBuf *codeBuffer) emitConvert(to types.ExpType, from types.ExpType) {
+	switch {
+	case to == types.String && from == types.Integer:
+		codeBuf.emitRO(tm.STR, ac, ac, 0)
+	case to == types.String && from == types.Real:
+		codeBuf.emitRO(tm.STRF, ac, ac, 0)
+	case to == types.Real && from == types.Integer:
+		codeBuf.emitRO(tm.FLT, ac, ac, 0)
+	}
+}
+
+// Procedure emitArith emits an integer or a real arithmetic instruction on ac1 and ac
+func (codeBuf *codeBuffer) emitArith(op tm.Opcode, realOp tm.Opcode, isReal bool) {
+	if isReal {
+		op = realOp
+	}
+	codeBuf.emitRO(op, ac, ac1, ac)
+}
+
+// Procedure emitIn reads a line of text, a real or an integer into ac
+func (codeBuf *codeBuffer) emitIn(expType types.ExpType) {
+	if expType == types.String {
+		codeBuf.emitRO(tm.INS, ac, 0, 0)
+	} else if expType == types.Real {
+		codeBuf.emitRO(tm.INF, ac, 0, 0)
+	} else {
+		codeBuf.emitRO(tm.IN, ac, 0, 0)
+	}
+}
+
+// Procedure emitCompare sets ac to 1 if the difference of ac1 and ac satisfies the jump, and to 0 otherwise
+func (codeBuf *codeBuffer) emitCompare(jump tm.Opcode, isReal bool) {
+	if isReal {
+		codeBuf.emitRO(tm.CMPF, ac, ac1, ac)
+	} else {
+		codeBuf.emitRO(tm.SUB, ac, ac1, ac)
+	}
+	codeBuf.emitRM(jump, ac, 2, pc)
+	codeBuf.emitRM(tm.LDC, ac, 0, ac)
+	codeBuf.emitRM(tm.LDA, pc, 1, pc)
+	codeBuf.emitRM(tm.LDC, ac, 1, ac)
+}
+
+// Procedure genLogic generates short-circuit code for and/or, the right operand is skipped once the left decides the result
+func genLogic(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBuf *codeBuffer) {
+	cGen(treeNode.Children[0], bucketMap, codeBuf)
+	savedLoc := codeBuf.emitSkip(1)
+	cGen(treeNode.Children[1], bucketMap, codeBuf)
+
+	loc := codeBuf.emitSkip(0)
+	codeBuf.emitBackup(savedLoc)
+	if treeNode.Op == types.AND {
+		codeBuf.emitRM_Abs(tm.JEQ, ac, loc)
+	} else {
+		codeBuf.emitRM_Abs(tm.JNE, ac, loc)
+	}
+	codeBuf.emitRestore()
+}
+
 // Procedure cGen recursively generates code by tree traversal
 func cGen(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBuf *codeBuffer) {
 	if treeNode != nil {
@@ -274,20 +619,79 @@ func cGen(treeNode *types.TreeNode, bucketMap map[string]types.Bucket, codeBuf *
 		case types.ExpK:
 			genExp(treeNode, bucketMap, codeBuf)
 		default:
-			err := errors.New(locale.Locale.CodegenUnknownTypeError)
-			panic(err)
+			codeBuf.codegenError(treeNode, "CodegenUnknownTypeError")
 		}
 		cGen(treeNode.Sibling, bucketMap, codeBuf)
 	}
 }
 
-func CodeGen(treeNode *types.TreeNode, bucketMap map[string]types.Bucket) []string {
-	codeBuf := &codeBuffer{make([]string, 0, 0), 0, 0, 0}
+func CodeGen(treeNode *types.TreeNode, bucketMap map[string]types.Bucket) ([]tm.Instruction, []types.Diagnostic) {
+	code, _, diagnostics := NewGenerator(locale.Locale).Generate(treeNode, bucketMap)
+
+	return code, diagnostics
+}
 
-	codeBuf.emitRM("LD", mp, 0, ac)
-	codeBuf.emitRM("ST", ac, 0, ac)
-	cGen(treeNode, bucketMap, codeBuf)
-	codeBuf.emitRO("HALT", 0, 0, 0)
+// Generator keeps code generation state between program fragments compiled by the interactive mode
+type Generator struct {
+	codeBuf *codeBuffer
+	start   int // Location where the next fragment starts, the HALT of the previous one
+}
+
+// Function NewGenerator returns a generator for programs in a locale whose first fragment begins with the program prologue
+func NewGenerator(loc *locale.LocaleType) *Generator {
+	codeBuf := &codeBuffer{make([]tm.Instruction, 0, 0), 0, 0, 0, nil, make(map[string]int), nil, "", make(map[int]Statement), loc, nil}
+
+	codeBuf.emitRM(tm.LD, mp, 0, ac)
+	codeBuf.emitRM(tm.ST, ac, 0, ac)
+
+	return &Generator{codeBuf, 0}
+}
+
+/*
+Function Generate generates code for the next program fragment, returning its instructions and the location execution starts from.
+The fragment overwrites the HALT of the previous one, so procedures generated earlier stay callable.
+The generator state only changes when generation succeeds.
+*/
+func (gen *Generator) Generate(treeNode *types.TreeNode, bucketMap map[string]types.Bucket) ([]tm.Instruction, int, []types.Diagnostic) {
+	codeBuf := *gen.codeBuf
+	codeBuf.procLoc = make(map[string]int)
+	for name, loc := range gen.codeBuf.procLoc {
+		codeBuf.procLoc[name] = loc
+	}
+	codeBuf.lines = make(map[int]Statement)
+	for loc, statement := range gen.codeBuf.lines {
+		codeBuf.lines[loc] = statement
+	}
+
+	// Globals declared by this fragment move the stack limit of every procedure, also of those generated before
+	codeBuf.emitRM(tm.LDC, sl, globalsSize(bucketMap)+tm.StackReserve, 0)
+	cGen(treeNode, bucketMap, &codeBuf)
+	haltLoc := codeBuf.emitLoc
+	codeBuf.emitRO(tm.HALT, 0, 0, 0)
+
+	// Backpatch calls made before the called procedure was generated
+	for _, call := range codeBuf.calls {
+		codeBuf.emitBackup(call.loc)
+		codeBuf.emitRM_Abs(tm.LDA, pc, codeBuf.procLoc[call.name])
+		codeBuf.emitRestore()
+	}
+
+	if len(codeBuf.diagnostics) > 0 {
+		return nil, gen.start, codeBuf.diagnostics
+	}
+
+	code, start := codeBuf.code, gen.start
+	codeBuf.code = make([]tm.Instruction, 0, 0)
+	codeBuf.calls = nil
+	codeBuf.emitLoc = haltLoc
+	codeBuf.highEmitLoc = haltLoc
+	gen.codeBuf = &codeBuf
+	gen.start = haltLoc
+
+	return code, start, nil
+}
 
-	return codeBuf.code
+// Function LineTable returns the source line of every location where the code of a statement starts
+func (gen *Generator) LineTable() map[int]Statement {
+	return gen.codeBuf.lines
 }
@@ -0,0 +1,191 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ivandejanovic/mlpl/codegen"
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/tm"
+	"github.com/ivandejanovic/mlpl/types"
+	"github.com/ivandejanovic/mlpl/vm"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type debugger struct {
+	process     *vm.Process
+	bucketMap   map[string]types.Bucket
+	lineTable   map[int]codegen.Statement
+	lines       []string     // Source lines, shown when the program stops
+	breakpoints map[int]bool // Source lines the program stops on when continuing
+}
+
+/*
+Function advance executes the program until it reaches the start of a statement.
+With toBreakpoint set it only stops at statements on lines with a breakpoint. It returns true once the program has stopped for good.
+*/
+func (dbg *debugger) advance(toBreakpoint bool) (bool, []types.Diagnostic) {
+	for {
+		if stopped, diagnostics := dbg.process.Step(); stopped {
+			return true, diagnostics
+		}
+
+		statement, ok := dbg.lineTable[dbg.process.PC()]
+		if ok && (!toBreakpoint || dbg.breakpoints[statement.Line]) {
+			return false, nil
+		}
+	}
+}
+
+// Procedure showLine prints the line of the statement the program stopped at
+func (dbg *debugger) showLine() {
+	line := dbg.lineTable[dbg.process.PC()].Line
+	source := ""
+	if line > 0 && line <= len(dbg.lines) {
+		source = strings.TrimSpace(dbg.lines[line-1])
+	}
+
+	fmt.Printf(locale.Locale.DebugStoppedMessage, line, source)
+}
+
+// Procedure setBreakpoint sets a breakpoint on a line that holds a statement
+func (dbg *debugger) setBreakpoint(line int) {
+	for _, statement := range dbg.lineTable {
+		if statement.Line == line {
+			dbg.breakpoints[line] = true
+			fmt.Printf(locale.Locale.DebugBreakpointMessage, line)
+			return
+		}
+	}
+
+	fmt.Printf(locale.Locale.DebugNoStatementError, line)
+}
+
+// Function value returns the value of a variable in the scope of the statement the program stopped at, arrays show all elements
+func (dbg *debugger) value(name string) (string, bool) {
+	var bucket types.Bucket
+	var ok bool
+
+	// The scanner composes names the same way
+	name = norm.NFC.String(name)
+
+	// Procedures only see their parameters and locals
+	proc := dbg.lineTable[dbg.process.PC()].Proc
+	if proc != "" {
+		bucket, ok = dbg.bucketMap[proc].Scope[name]
+	} else {
+		bucket, ok = dbg.bucketMap[name]
+	}
+	if !ok || bucket.Kind == types.ProcSym {
+		return "", false
+	}
+
+	offset, reg := codegen.Locate(bucket, proc != "")
+	address := int(dbg.process.Register(reg)) + offset
+
+	if bucket.Kind != types.ArraySym {
+		cell, _ := dbg.process.Memory(address)
+		return dbg.process.Format(cell, bucket.Type), true
+	}
+
+	elements := make([]string, 0, bucket.Size)
+	for index := 0; index < bucket.Size; index++ {
+		cell, _ := dbg.process.Memory(address + index)
+		elements = append(elements, dbg.process.Format(cell, bucket.Type))
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]", true
+}
+
+/*
+Function Run runs a compiled program under the control of debugger commands read from standard input.
+The program stops before its first statement, and its own input is read from standard input as well.
+It returns the diagnostics of a runtime error that ended the program.
+*/
+func Run(code []tm.Instruction, bucketMap map[string]types.Bucket, lineTable map[int]codegen.Statement, lines []string) []types.Diagnostic {
+	in := bufio.NewReader(os.Stdin)
+
+	process, diagnostics := vm.Load(code, lineTable, in)
+	if diagnostics != nil {
+		return diagnostics
+	}
+
+	dbg := &debugger{process, bucketMap, lineTable, lines, make(map[int]bool)}
+
+	stopped, diagnostics := dbg.advance(false)
+	for !stopped {
+		dbg.showLine()
+
+		// Commands that do not run the program ask for the next command at the same statement
+		for running := false; !running; {
+			fmt.Print(locale.Locale.DebugPrompt)
+			command, err := in.ReadString('\n')
+			if err != nil && command == "" {
+				fmt.Println()
+				return nil
+			}
+
+			fields := strings.Fields(command)
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch {
+			case fields[0] == locale.Locale.DebugStepCommand:
+				stopped, diagnostics = dbg.advance(false)
+				running = true
+			case fields[0] == locale.Locale.DebugContinueCommand:
+				stopped, diagnostics = dbg.advance(true)
+				running = true
+			case fields[0] == locale.Locale.DebugBreakCommand && len(fields) == 2:
+				if line, err := strconv.Atoi(fields[1]); err == nil {
+					dbg.setBreakpoint(line)
+				} else {
+					fmt.Printf(locale.Locale.DebugUnknownCommandError, strings.TrimSpace(command))
+				}
+			case fields[0] == locale.Locale.DebugPrintCommand && len(fields) == 2:
+				if value, ok := dbg.value(fields[1]); ok {
+					fmt.Printf(locale.Locale.DebugValueMessage, fields[1], value)
+				} else {
+					fmt.Printf(locale.Locale.DebugUnknownVariableError, fields[1])
+				}
+			case fields[0] == locale.Locale.DebugQuitCommand:
+				return nil
+			default:
+				fmt.Printf(locale.Locale.DebugUnknownCommandError, strings.TrimSpace(command))
+			}
+		}
+	}
+
+	if diagnostics == nil {
+		fmt.Print(locale.Locale.DebugFinishedMessage)
+	}
+
+	return diagnostics
+}
@@ -30,79 +30,121 @@ import (
 	"github.com/ivandejanovic/mlpl/locale"
 	"github.com/ivandejanovic/mlpl/types"
 	"strconv"
+	"strings"
 )
 
 type lexBuffer struct {
-	token  types.Token
-	index  int
-	tokens []types.Token
+	token       types.Token
+	index       int
+	tokens      []types.Token
+	depth       int                // Nesting level of statement sequences, procedures may only be declared at level one
+	locale      *locale.LocaleType // Locale of error messages and the decimal separator
+	reported    types.Token        // Last token reported as unexpected, every enclosing block runs into a stray terminator again
+	diagnostics []types.Diagnostic
 }
 
-func syntaxError(token types.Token) {
-	fmt.Printf(locale.Locale.LexerSyntaxError, token.Lineno)
+var errAbort = errors.New("syntax error")
+
+// Function tokenDescription returns the localized description of a token for syntax error messages
+func (buffer *lexBuffer) tokenDescription(token types.Token) string {
+	var description string
 
 	switch token.TokenType {
-	case types.IF, types.THEN, types.ELSE, types.END, types.REPEAT, types.UNTIL, types.READ, types.WRITE:
-		fmt.Printf(locale.Locale.LexerReservedWordError, token.TokenString)
+	case types.IF, types.THEN, types.ELSE, types.END, types.REPEAT, types.UNTIL, types.READ, types.WRITE, types.WHILE, types.DO, types.PROCEDURE, types.RETURN, types.AND, types.OR, types.NOT, types.ARRAY, types.LENGTH, types.TRUE, types.FALSE:
+		description = fmt.Sprintf(buffer.locale.LexerReservedWordError, token.TokenString)
 	case types.ASSIGN:
-		fmt.Printf(locale.Locale.LexerAssignError)
+		description = buffer.locale.LexerAssignError
 	case types.LT:
-		fmt.Printf(locale.Locale.LexerLTError)
+		description = buffer.locale.LexerLTError
+	case types.GT:
+		description = buffer.locale.LexerGTError
+	case types.LE:
+		description = buffer.locale.LexerLEError
+	case types.GE:
+		description = buffer.locale.LexerGEError
+	case types.NE:
+		description = buffer.locale.LexerNEError
 	case types.EQ:
-		fmt.Printf(locale.Locale.LexerEQError)
+		description = buffer.locale.LexerEQError
 	case types.LPAREN:
-		fmt.Printf(locale.Locale.LexerLPARENError)
+		description = buffer.locale.LexerLPARENError
 	case types.RPAREN:
-		fmt.Printf(locale.Locale.LexerRPARENError)
+		description = buffer.locale.LexerRPARENError
+	case types.LBRACKET:
+		description = buffer.locale.LexerLBRACKETError
+	case types.RBRACKET:
+		description = buffer.locale.LexerRBRACKETError
 	case types.SEMI:
-		fmt.Printf(locale.Locale.LexerSEMIError)
+		description = buffer.locale.LexerSEMIError
+	case types.COMMA:
+		description = buffer.locale.LexerCOMMAError
 	case types.PLUS:
-		fmt.Printf(locale.Locale.LexerPLUSError)
+		description = buffer.locale.LexerPLUSError
 	case types.MINUS:
-		fmt.Printf(locale.Locale.LexerMINUSError)
+		description = buffer.locale.LexerMINUSError
 	case types.TIMES:
-		fmt.Printf(locale.Locale.LexerTIMESError)
+		description = buffer.locale.LexerTIMESError
 	case types.OVER:
-		fmt.Printf(locale.Locale.LexerOVERError)
+		description = buffer.locale.LexerOVERError
 	case types.ENDFILE:
-		fmt.Printf(locale.Locale.LexerENDFILEError)
-	case types.NUM:
-		fmt.Printf(locale.Locale.LexerNUMError, token.TokenString)
+		description = buffer.locale.LexerENDFILEError
+	case types.NUM, types.REAL:
+		description = fmt.Sprintf(buffer.locale.LexerNUMError, token.TokenString)
 	case types.ID:
-		fmt.Printf(locale.Locale.LexerIDError, token.TokenString)
+		description = fmt.Sprintf(buffer.locale.LexerIDError, token.TokenString)
 	case types.ERROR:
-		fmt.Printf(locale.Locale.LexerERRORError, token.TokenString)
+		description = fmt.Sprintf(buffer.locale.LexerERRORError, token.TokenString)
 	default:
 		// Should never happen.
-		fmt.Printf(locale.Locale.LexerDEFAULTError, token.TokenType)
+		description = fmt.Sprintf(buffer.locale.LexerDEFAULTError, token.TokenType)
 	}
 
-	err := errors.New(locale.Locale.LexerABORTINGError)
-	if err != nil {
-		panic(err)
+	return strings.TrimRight(description, "\n")
+}
+
+/*
+Procedure syntaxError records an unexpected token and abandons the statement being parsed.
+The panic carries errAbort and is recovered in recoverStatement, it never leaves the package.
+*/
+func (buffer *lexBuffer) syntaxError(token types.Token) {
+	buffer.unexpected(token)
+
+	panic(errAbort)
+}
+
+// Procedure unexpected records an unexpected token without abandoning anything, a token already reported is not reported again
+func (buffer *lexBuffer) unexpected(token types.Token) {
+	if token == buffer.reported {
+		return
 	}
+	buffer.reported = token
+
+	diagnostic := types.Diagnostic{Line: token.Lineno, Column: token.Column, Severity: types.ErrorSeverity, Key: "LexerSyntaxError", Args: []interface{}{buffer.tokenDescription(token)}}
+	buffer.diagnostics = append(buffer.diagnostics, diagnostic)
 }
 
-func newStmtNode(kind types.StmtKind, lineno int) *types.TreeNode {
+func newStmtNode(kind types.StmtKind, token types.Token) *types.TreeNode {
 	node := new(types.TreeNode)
 
 	node.Children = make([]*types.TreeNode, 0, 0)
 	node.Sibling = nil
 	node.Node = types.StmtK
 	node.Stmt = kind
-	node.Lineno = lineno
+	node.Lineno = token.Lineno
+	node.Column = token.Column
 
 	return node
 }
 
-func newExpNode(kind types.ExpKind, lineno int) *types.TreeNode {
+func newExpNode(kind types.ExpKind, token types.Token) *types.TreeNode {
 	node := new(types.TreeNode)
 
 	node.Children = make([]*types.TreeNode, 0, 0)
 	node.Sibling = nil
 	node.Node = types.ExpK
 	node.Exp = kind
-	node.Lineno = lineno
+	node.Lineno = token.Lineno
+	node.Column = token.Column
 
 	return node
 }
@@ -114,11 +156,19 @@ func (buffer *lexBuffer) nextToken() {
 	}
 }
 
+func (buffer *lexBuffer) peekToken() types.TokenType {
+	if buffer.index+1 < len(buffer.tokens) {
+		return buffer.tokens[buffer.index+1].TokenType
+	}
+
+	return types.ENDFILE
+}
+
 func (buffer *lexBuffer) match(expected types.TokenType) {
 	if buffer.token.TokenType == expected {
 		buffer.nextToken()
 	} else {
-		syntaxError(buffer.token)
+		buffer.syntaxError(buffer.token)
 	}
 }
 
@@ -128,22 +178,58 @@ func (buffer *lexBuffer) factor() *types.TreeNode {
 
 	switch buffer.token.TokenType {
 	case types.NUM:
-		node = newExpNode(types.ConstK, buffer.token.Lineno)
+		node = newExpNode(types.ConstK, buffer.token)
 		if buffer.token.TokenType == types.NUM {
-			node.Val, err = strconv.Atoi(buffer.token.TokenString)
+			node.Val, err = strconv.Atoi(locale.ASCIIDigits(buffer.token.TokenString))
 			if err != nil {
-				panic(err)
+				buffer.syntaxError(buffer.token)
 			}
 		}
 		buffer.match(types.NUM)
 	case types.ID:
-		node = newExpNode(types.IdK, buffer.token.Lineno)
+		if buffer.peekToken() == types.LPAREN {
+			node = newExpNode(types.CallExpK, buffer.token)
+			node.Name = buffer.token.TokenString
+			buffer.match(types.ID)
+			node.Children = buffer.args()
+			break
+		}
+		if buffer.peekToken() == types.LBRACKET {
+			node = newExpNode(types.IndexK, buffer.token)
+			node.Name = buffer.token.TokenString
+			buffer.match(types.ID)
+			node.Children = append(node.Children, buffer.subscript())
+			break
+		}
+		node = newExpNode(types.IdK, buffer.token)
 		if buffer.token.TokenType == types.ID {
 			node.Name = buffer.token.TokenString
 		}
 		buffer.match(types.ID)
+	case types.LENGTH:
+		node = newExpNode(types.LengthK, buffer.token)
+		buffer.match(types.LENGTH)
+		buffer.match(types.LPAREN)
+		if buffer.token.TokenType == types.ID {
+			node.Name = buffer.token.TokenString
+		}
+		buffer.match(types.ID)
+		buffer.match(types.RPAREN)
+	case types.REAL:
+		node = newExpNode(types.RealK, buffer.token)
+		node.ValReal, err = strconv.ParseFloat(strings.Replace(locale.ASCIIDigits(buffer.token.TokenString), buffer.locale.DecimalSeparator, ".", 1), 64)
+		if err != nil {
+			buffer.syntaxError(buffer.token)
+		}
+		buffer.match(types.REAL)
+	case types.TRUE, types.FALSE:
+		node = newExpNode(types.BoolK, buffer.token)
+		if buffer.token.TokenType == types.TRUE {
+			node.Val = 1
+		}
+		buffer.match(buffer.token.TokenType)
 	case types.STRING:
-		node = newExpNode(types.StringK, buffer.token.Lineno)
+		node = newExpNode(types.StringK, buffer.token)
 		node.ValString = buffer.token.TokenString
 		buffer.match(types.STRING)
 	case types.LPAREN:
@@ -151,17 +237,43 @@ func (buffer *lexBuffer) factor() *types.TreeNode {
 		node = buffer.exp()
 		buffer.match(types.RPAREN)
 	default:
-		syntaxError(buffer.token)
+		buffer.syntaxError(buffer.token)
 	}
 
 	return node
 }
 
+// Function subscript parses a bracketed array index
+func (buffer *lexBuffer) subscript() *types.TreeNode {
+	buffer.match(types.LBRACKET)
+	node := buffer.exp()
+	buffer.match(types.RBRACKET)
+
+	return node
+}
+
+// Function args parses a parenthesized, comma separated list of call arguments
+func (buffer *lexBuffer) args() []*types.TreeNode {
+	args := make([]*types.TreeNode, 0, 0)
+
+	buffer.match(types.LPAREN)
+	if buffer.token.TokenType != types.RPAREN {
+		args = append(args, buffer.exp())
+		for buffer.token.TokenType == types.COMMA {
+			buffer.match(types.COMMA)
+			args = append(args, buffer.exp())
+		}
+	}
+	buffer.match(types.RPAREN)
+
+	return args
+}
+
 func (buffer *lexBuffer) term() *types.TreeNode {
 	node := buffer.factor()
 
 	for buffer.token.TokenType == types.TIMES || buffer.token.TokenType == types.OVER {
-		p := newExpNode(types.OpK, buffer.token.Lineno)
+		p := newExpNode(types.OpK, buffer.token)
 		p.Children = append(p.Children, node)
 		p.Op = buffer.token.TokenType
 		node = p
@@ -176,7 +288,7 @@ func (buffer *lexBuffer) simpleExp() *types.TreeNode {
 	node := buffer.term()
 
 	for buffer.token.TokenType == types.PLUS || buffer.token.TokenType == types.MINUS {
-		p := newExpNode(types.OpK, buffer.token.Lineno)
+		p := newExpNode(types.OpK, buffer.token)
 		p.Children = append(p.Children, node)
 		p.Op = buffer.token.TokenType
 		node = p
@@ -187,11 +299,20 @@ func (buffer *lexBuffer) simpleExp() *types.TreeNode {
 	return node
 }
 
-func (buffer *lexBuffer) exp() *types.TreeNode {
+func isRelop(tokenType types.TokenType) bool {
+	switch tokenType {
+	case types.LT, types.GT, types.LE, types.GE, types.EQ, types.NE:
+		return true
+	}
+
+	return false
+}
+
+func (buffer *lexBuffer) relExp() *types.TreeNode {
 	node := buffer.simpleExp()
 
-	if buffer.token.TokenType == types.LT || buffer.token.TokenType == types.EQ {
-		p := newExpNode(types.OpK, buffer.token.Lineno)
+	if isRelop(buffer.token.TokenType) {
+		p := newExpNode(types.OpK, buffer.token)
 		p.Children = append(p.Children, node)
 		p.Op = buffer.token.TokenType
 		node = p
@@ -202,8 +323,50 @@ func (buffer *lexBuffer) exp() *types.TreeNode {
 	return node
 }
 
+func (buffer *lexBuffer) notExp() *types.TreeNode {
+	if buffer.token.TokenType == types.NOT {
+		node := newExpNode(types.OpK, buffer.token)
+		node.Op = types.NOT
+		buffer.match(types.NOT)
+		node.Children = append(node.Children, buffer.notExp())
+		return node
+	}
+
+	return buffer.relExp()
+}
+
+func (buffer *lexBuffer) andExp() *types.TreeNode {
+	node := buffer.notExp()
+
+	for buffer.token.TokenType == types.AND {
+		p := newExpNode(types.OpK, buffer.token)
+		p.Children = append(p.Children, node)
+		p.Op = buffer.token.TokenType
+		node = p
+		buffer.match(buffer.token.TokenType)
+		node.Children = append(node.Children, buffer.notExp())
+	}
+
+	return node
+}
+
+func (buffer *lexBuffer) exp() *types.TreeNode {
+	node := buffer.andExp()
+
+	for buffer.token.TokenType == types.OR {
+		p := newExpNode(types.OpK, buffer.token)
+		p.Children = append(p.Children, node)
+		p.Op = buffer.token.TokenType
+		node = p
+		buffer.match(buffer.token.TokenType)
+		node.Children = append(node.Children, buffer.andExp())
+	}
+
+	return node
+}
+
 func (buffer *lexBuffer) ifStmt() *types.TreeNode {
-	node := newStmtNode(types.IfK, buffer.token.Lineno)
+	node := newStmtNode(types.IfK, buffer.token)
 
 	buffer.match(types.IF)
 	node.Children = append(node.Children, buffer.exp())
@@ -213,12 +376,13 @@ func (buffer *lexBuffer) ifStmt() *types.TreeNode {
 		buffer.match(types.ELSE)
 		node.Children = append(node.Children, buffer.stmtSequence())
 	}
+	buffer.match(types.END)
 
 	return node
 }
 
 func (buffer *lexBuffer) repeatStmt() *types.TreeNode {
-	node := newStmtNode(types.RepeatK, buffer.token.Lineno)
+	node := newStmtNode(types.RepeatK, buffer.token)
 
 	buffer.match(types.REPEAT)
 	node.Children = append(node.Children, buffer.stmtSequence())
@@ -228,35 +392,144 @@ func (buffer *lexBuffer) repeatStmt() *types.TreeNode {
 	return node
 }
 
+func (buffer *lexBuffer) whileStmt() *types.TreeNode {
+	node := newStmtNode(types.WhileK, buffer.token)
+
+	buffer.match(types.WHILE)
+	node.Children = append(node.Children, buffer.exp())
+	buffer.match(types.DO)
+	node.Children = append(node.Children, buffer.stmtSequence())
+	buffer.match(types.END)
+
+	return node
+}
+
 func (buffer *lexBuffer) assignStmt() *types.TreeNode {
-	node := newStmtNode(types.AssignK, buffer.token.Lineno)
+	node := newStmtNode(types.AssignK, buffer.token)
 
 	if buffer.token.TokenType == types.ID {
 		node.Name = buffer.token.TokenString
 	}
 	buffer.match(types.ID)
+
+	// An array element target keeps its index as the second child
+	var index *types.TreeNode
+	if buffer.token.TokenType == types.LBRACKET {
+		index = buffer.subscript()
+	}
 	buffer.match(types.ASSIGN)
 	node.Children = append(node.Children, buffer.exp())
+	if index != nil {
+		node.Children = append(node.Children, index)
+	}
+	buffer.match(types.SEMI)
+
+	return node
+}
+
+func (buffer *lexBuffer) callStmt() *types.TreeNode {
+	node := newStmtNode(types.CallK, buffer.token)
+
+	if buffer.token.TokenType == types.ID {
+		node.Name = buffer.token.TokenString
+	}
+	buffer.match(types.ID)
+	node.Children = buffer.args()
+	buffer.match(types.SEMI)
+
+	return node
+}
+
+func (buffer *lexBuffer) param() *types.TreeNode {
+	node := newExpNode(types.IdK, buffer.token)
+
+	if buffer.token.TokenType == types.ID {
+		node.Name = buffer.token.TokenString
+	}
+	buffer.match(types.ID)
+
+	return node
+}
+
+func (buffer *lexBuffer) procStmt() *types.TreeNode {
+	node := newStmtNode(types.ProcK, buffer.token)
+
+	buffer.match(types.PROCEDURE)
+	if buffer.token.TokenType == types.ID {
+		node.Name = buffer.token.TokenString
+	}
+	buffer.match(types.ID)
+
+	// Parameters are kept as identifier children in front of the body
+	buffer.match(types.LPAREN)
+	if buffer.token.TokenType != types.RPAREN {
+		node.Children = append(node.Children, buffer.param())
+		for buffer.token.TokenType == types.COMMA {
+			buffer.match(types.COMMA)
+			node.Children = append(node.Children, buffer.param())
+		}
+	}
+	buffer.match(types.RPAREN)
+
+	node.Children = append(node.Children, buffer.stmtSequence())
+	buffer.match(types.END)
+
+	return node
+}
+
+func (buffer *lexBuffer) returnStmt() *types.TreeNode {
+	node := newStmtNode(types.ReturnK, buffer.token)
+
+	buffer.match(types.RETURN)
+	if buffer.token.TokenType != types.SEMI {
+		node.Children = append(node.Children, buffer.exp())
+	}
+	buffer.match(types.SEMI)
+
+	return node
+}
+
+func (buffer *lexBuffer) arrayStmt() *types.TreeNode {
+	var err error
+	node := newStmtNode(types.ArrayK, buffer.token)
+
+	buffer.match(types.ARRAY)
+	if buffer.token.TokenType == types.ID {
+		node.Name = buffer.token.TokenString
+	}
+	buffer.match(types.ID)
+	buffer.match(types.LBRACKET)
+	if buffer.token.TokenType == types.NUM {
+		node.Val, err = strconv.Atoi(locale.ASCIIDigits(buffer.token.TokenString))
+		if err != nil {
+			buffer.syntaxError(buffer.token)
+		}
+	}
+	buffer.match(types.NUM)
+	buffer.match(types.RBRACKET)
 	buffer.match(types.SEMI)
 
 	return node
 }
 
 func (buffer *lexBuffer) readStmt() *types.TreeNode {
-	node := newStmtNode(types.ReadK, buffer.token.Lineno)
+	node := newStmtNode(types.ReadK, buffer.token)
 
 	buffer.match(types.READ)
 	if buffer.token.TokenType == types.ID {
 		node.Name = buffer.token.TokenString
 	}
 	buffer.match(types.ID)
+	if buffer.token.TokenType == types.LBRACKET {
+		node.Children = append(node.Children, buffer.subscript())
+	}
 	buffer.match(types.SEMI)
 
 	return node
 }
 
 func (buffer *lexBuffer) writeStmt() *types.TreeNode {
-	node := newStmtNode(types.WriteK, buffer.token.Lineno)
+	node := newStmtNode(types.WriteK, buffer.token)
 
 	buffer.match(types.WRITE)
 	node.Children = append(node.Children, buffer.exp())
@@ -273,28 +546,97 @@ func (buffer *lexBuffer) statement() *types.TreeNode {
 		node = buffer.ifStmt()
 	case types.REPEAT:
 		node = buffer.repeatStmt()
+	case types.WHILE:
+		node = buffer.whileStmt()
 	case types.ID:
-		node = buffer.assignStmt()
+		if buffer.peekToken() == types.LPAREN {
+			node = buffer.callStmt()
+		} else {
+			node = buffer.assignStmt()
+		}
+	case types.PROCEDURE:
+		if buffer.depth > 1 {
+			buffer.syntaxError(buffer.token)
+		}
+		node = buffer.procStmt()
+	case types.RETURN:
+		node = buffer.returnStmt()
+	case types.ARRAY:
+		node = buffer.arrayStmt()
 	case types.READ:
 		node = buffer.readStmt()
 	case types.WRITE:
 		node = buffer.writeStmt()
 	default:
-		syntaxError(buffer.token)
+		buffer.syntaxError(buffer.token)
 	}
 
 	return node
 }
 
+// Function startsStatement tells whether the current token begins a statement, an identifier only at the start of a line
+func (buffer *lexBuffer) startsStatement() bool {
+	switch buffer.token.TokenType {
+	case types.IF, types.WHILE, types.REPEAT, types.READ, types.WRITE, types.RETURN, types.ARRAY, types.PROCEDURE:
+		return true
+	case types.ID:
+		return buffer.index > 0 && buffer.tokens[buffer.index-1].Lineno < buffer.token.Lineno
+	}
+
+	return false
+}
+
+/*
+Procedure synchronize skips tokens after a syntax error up to the end of the statement or of the enclosing block.
+It also stops in front of the next statement, such as one that follows a missing semicolon, but only past start,
+the index of the first token of the failed statement, so every failed statement consumes at least one token.
+*/
+func (buffer *lexBuffer) synchronize(start int) {
+	for {
+		switch {
+		case buffer.token.TokenType == types.SEMI:
+			buffer.nextToken()
+			return
+		case buffer.token.TokenType == types.END, buffer.token.TokenType == types.ELSE, buffer.token.TokenType == types.UNTIL, buffer.token.TokenType == types.ENDFILE:
+			return
+		case buffer.index > start && buffer.startsStatement():
+			return
+		}
+		buffer.nextToken()
+	}
+}
+
+/*
+Function recoverStatement parses a statement in panic mode.
+After a syntax error it synchronizes and returns nil, so parsing goes on with the next statement and every error gets reported.
+*/
+func (buffer *lexBuffer) recoverStatement() (node *types.TreeNode) {
+	start := buffer.index
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errAbort {
+				panic(r)
+			}
+			buffer.synchronize(start)
+			node = nil
+		}
+	}()
+
+	return buffer.statement()
+}
+
 func (buffer *lexBuffer) stmtSequence() *types.TreeNode {
-	node := buffer.statement()
+	buffer.depth++
+	defer func() { buffer.depth-- }()
+
+	node := buffer.recoverStatement()
 	p := node
 
 	for buffer.token.TokenType != types.ENDFILE &&
 		buffer.token.TokenType != types.END &&
 		buffer.token.TokenType != types.ELSE &&
 		buffer.token.TokenType != types.UNTIL {
-		q := buffer.statement()
+		q := buffer.recoverStatement()
 		if q != nil {
 			if node == nil {
 				p = q
@@ -314,9 +656,11 @@ func (buffer *lexBuffer) lexSequence() *types.TreeNode {
 	var node, p, q *types.TreeNode = nil, nil, nil
 
 	for buffer.token.TokenType != types.ENDFILE {
+		// A terminator that closes no block is reported and skipped
 		if buffer.token.TokenType == types.END ||
 			buffer.token.TokenType == types.ELSE ||
 			buffer.token.TokenType == types.UNTIL {
+			buffer.unexpected(buffer.token)
 			buffer.nextToken()
 		}
 		if buffer.token.TokenType == types.ENDFILE {
@@ -339,8 +683,10 @@ func (buffer *lexBuffer) lexSequence() *types.TreeNode {
 	return node
 }
 
-func Lex(tokens []types.Token) *types.TreeNode {
-	buffer := &lexBuffer{tokens[0], 0, tokens}
+// Function Lex builds the syntax tree and reports all syntax errors, statements with errors are left out of the tree
+func Lex(tokens []types.Token, loc *locale.LocaleType) (*types.TreeNode, []types.Diagnostic) {
+	buffer := &lexBuffer{tokens[0], 0, tokens, 0, loc, types.Token{}, nil}
+	treeNode := buffer.lexSequence()
 
-	return buffer.lexSequence()
+	return treeNode, buffer.diagnostics
 }
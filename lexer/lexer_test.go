@@ -0,0 +1,104 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lexer
+
+import (
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/parse"
+	"github.com/ivandejanovic/mlpl/types"
+	"strings"
+	"testing"
+)
+
+// position is where a syntax error points
+type position struct {
+	line, column int
+}
+
+// Function syntaxErrors returns the positions of the syntax errors in English source
+func syntaxErrors(t *testing.T, source string) []position {
+	loc := locale.New()
+	tokens, diagnostics := parse.ParseReader(strings.NewReader(source), loc)
+	if len(diagnostics) > 0 {
+		t.Fatalf("%q: scanning reported %v", source, diagnostics)
+	}
+
+	_, diagnostics = Lex(tokens, loc)
+	positions := make([]position, 0, len(diagnostics))
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Key != "LexerSyntaxError" || diagnostic.Severity != types.ErrorSeverity {
+			t.Errorf("%q: unexpected diagnostic %v", source, diagnostic)
+		}
+		positions = append(positions, position{diagnostic.Line, diagnostic.Column})
+	}
+
+	return positions
+}
+
+// Procedure checkErrors fails the test unless the syntax errors of source are at the wanted positions
+func checkErrors(t *testing.T, source string, want []position) {
+	got := syntaxErrors(t, source)
+	if len(got) != len(want) {
+		t.Errorf("%q: got errors at %v, want %v", source, got, want)
+		return
+	}
+	for index := range got {
+		if got[index] != want[index] {
+			t.Errorf("%q: got errors at %v, want %v", source, got, want)
+			return
+		}
+	}
+}
+
+func TestStrayTerminators(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []position
+	}{
+		{"write 1;\nend\nwrite 2;\n", []position{{2, 1}}},
+		{"else\nuntil\n", []position{{1, 1}, {2, 1}}},
+		{"if 1 < 2 then write 1; else\nuntil\n", []position{{2, 1}}},
+		{"repeat\n  if 1 < 2 then\n    write 1;\n  until 1 < 2\n", []position{{4, 3}}},
+	}
+
+	for _, test := range tests {
+		checkErrors(t, test.source, test.want)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []position
+	}{
+		{"x := 1\nif x < 2 then write 1; end\n", []position{{2, 1}}},
+		{"x := 1\ny := 2;\nwrite x +;\nwrite y\nwhile y < 3 do y := y + 1; end\n", []position{{2, 1}, {3, 10}, {5, 1}}},
+		{"write 1 write 2;\n", []position{{1, 9}}},
+	}
+
+	for _, test := range tests {
+		checkErrors(t, test.source, test.want)
+	}
+}
@@ -25,25 +25,91 @@ SOFTWARE.
 package locale
 
 import (
-	"errors"
+	"bytes"
+	"embed"
+	"encoding/json"
 	"fmt"
 	"github.com/ivandejanovic/mlpl/types"
+	"golang.org/x/text/unicode/norm"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// Configurations of the locales built into the interpreter, one file per locale named after it
+//
+//go:embed localization/*.cfg
+var bundled embed.FS
+
+const pragma = "#! locale:"
+
+// Names of the bundled locales by language code, as used in LANG, LC_ALL and --lang
+var languages = map[string]string{
+	"en": "english",
+	"es": "spanish",
+	"fr": "french",
+	"ru": "russian",
+	"sr": "serbian",
+}
+
 type LocaleType struct {
-	ReservedArray []string
-	Reserved      []types.ReservedWord
+	Name    string `json:"-"` // Taken from the name of the configuration file
+	Extends string // Locale that supplies the keys a configuration leaves out
+
+	ReservedArray []Aliases
+	Reserved      map[string]types.TokenType `json:"-"` // Every spelling of every keyword, assembled from ReservedArray
+
+	DecimalSeparator string
+	NativeDigits     string // Digits from zero to nine numbers are written and read with, empty for ASCII digits
 
-	ParseError string
+	DiagnosticError   string
+	DiagnosticWarning string
+
+	ConfigFileError           string
+	LocaleReservedLengthError string
+	LocaleNotFoundError       string
+	ArgumentValueError        string
+	WriteFileError            string
+	BytecodeFormatError       string
+	BytecodeVersionError      string
+
+	LocaleSyntaxError           string
+	LocaleUnknownKeyError       string
+	LocaleMissingKeyError       string
+	LocaleIdentifierError       string
+	LocaleDuplicateKeywordError string
+	LocaleCaseCollisionWarning  string
+	LocaleVerbError             string
+	LocaleExtendsCycleError     string
+	LocaleFallbackWarning       string
+	LocaleKeywordMissingError   string
+	LocaleNativeDigitsError     string
+
+	ParseError     string
+	ParseFileError string
 
 	LexerSyntaxError       string
 	LexerReservedWordError string
 	LexerAssignError       string
 	LexerLTError           string
+	LexerGTError           string
+	LexerLEError           string
+	LexerGEError           string
+	LexerNEError           string
 	LexerEQError           string
 	LexerLPARENError       string
 	LexerRPARENError       string
+	LexerLBRACKETError     string
+	LexerRBRACKETError     string
 	LexerSEMIError         string
+	LexerCOMMAError        string
 	LexerPLUSError         string
 	LexerMINUSError        string
 	LexerTIMESError        string
@@ -53,14 +119,30 @@ type LocaleType struct {
 	LexerIDError           string
 	LexerERRORError        string
 	LexerDEFAULTError      string
-	LexerABORTINGError     string
 
-	AnalyzeTypePrefixError string
-	AnalyzeTypeOpError     string
-	AnalyzeTypeIfError     string
-	AnalyzeTypeAssignError string
-	AnalyzeTypeWriteError  string
-	AnalyzeTypeRepeatError string
+	AnalyzeTypeOpError            string
+	AnalyzeTypeLogicError         string
+	AnalyzeTypeIfError            string
+	AnalyzeTypeAssignError        string
+	AnalyzeTypeMismatchError      string
+	AnalyzeTypeReadError          string
+	AnalyzeTypeWriteError         string
+	AnalyzeTypeRepeatError        string
+	AnalyzeTypeWhileError         string
+	AnalyzeTypeArgumentError      string
+	AnalyzeTypeReturnError        string
+	AnalyzeTypeIndexError         string
+	AnalyzeProcRedefinedError     string
+	AnalyzeProcParamError         string
+	AnalyzeProcUndefinedError     string
+	AnalyzeProcArgumentsError     string
+	AnalyzeProcNameError          string
+	AnalyzeReturnError            string
+	AnalyzeArrayDeclarationError  string
+	AnalyzeArraySizeError         string
+	AnalyzeArrayMemoryError       string
+	AnalyzeArrayIndexMissingError string
+	AnalyzeNotArrayError          string
 
 	CodegenUnknownOperatorError string
 	CodegenUnknownTypeError     string
@@ -77,37 +159,142 @@ type LocaleType struct {
 	VmInvalidProgramCounterError    string
 	VmInvalidMemoryAddressError     string
 	VmNonIntegerEnteredError        string
+	VmNonNumberEnteredError         string
+	VmEndOfInputError               string
 	VmDivisionWIthZeroError         string
+	VmIndexOutOfRangeError          string
+	VmCancelledError                string
+	VmStepLimitError                string
+	VmTimeoutError                  string
+	VmStackOverflowError            string
+	VmInvalidRegisterError          string
+	VmInvalidStringError            string
+
+	AsmUnknownLabelError             string
+	AsmDuplicateLabelError           string
+	AsmDuplicateLocationError        string
+	AsmMemoryLocationError           string
+	AsmMissingOpcodeError            string
+	AsmInvalidOpcodeError            string
+	AsmInvalidNumberOfArgumentsError string
+	AsmInvalidFirstArgumentError     string
+	AsmInvalidSecondArgumentError    string
+	AsmInvalidThirdArgumentError     string
+	AsmInvalidRegisterError          string
+	AsmLineComment                   string
+
+	DebugPrompt               string
+	DebugStepCommand          string
+	DebugBreakCommand         string
+	DebugContinueCommand      string
+	DebugPrintCommand         string
+	DebugQuitCommand          string
+	DebugStoppedMessage       string
+	DebugBreakpointMessage    string
+	DebugValueMessage         string
+	DebugFinishedMessage      string
+	DebugNoStatementError     string
+	DebugUnknownVariableError string
+	DebugUnknownCommandError  string
+
+	TranslateKeywordWarning string
 }
 
 var Locale *LocaleType = new(LocaleType)
 
-const ReservedLength int = 8
+var defaults LocaleType // English locale built by init, the starting point of every loaded configuration
+
+const ReservedLength int = 19
+
+// Token types of the keywords in ReservedArray, in order
+var reservedTypes = [ReservedLength]types.TokenType{
+	types.IF, types.THEN, types.ELSE, types.END, types.REPEAT, types.UNTIL, types.READ, types.WRITE, types.WHILE, types.DO,
+	types.PROCEDURE, types.RETURN, types.AND, types.OR, types.NOT, types.ARRAY, types.LENGTH, types.TRUE, types.FALSE,
+}
+
+// Aliases holds the spellings of one keyword, the first one is used when a keyword is written out
+type Aliases []string
+
+// Function UnmarshalJSON reads the spellings of a keyword from a list, or from a single string for a keyword without synonyms
+func (aliases *Aliases) UnmarshalJSON(data []byte) error {
+	var spelling string
+	if err := json.Unmarshal(data, &spelling); err == nil {
+		*aliases = Aliases{spelling}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(aliases))
+}
 
 func init() {
-	var reserved []string
+	var reserved []Aliases
 
-	reserved = append(reserved, "if")
-	reserved = append(reserved, "then")
-	reserved = append(reserved, "else")
-	reserved = append(reserved, "end")
-	reserved = append(reserved, "repeat")
-	reserved = append(reserved, "until")
-	reserved = append(reserved, "read")
-	reserved = append(reserved, "write")
+	reserved = append(reserved, Aliases{"if"})
+	reserved = append(reserved, Aliases{"then"})
+	reserved = append(reserved, Aliases{"else"})
+	reserved = append(reserved, Aliases{"end"})
+	reserved = append(reserved, Aliases{"repeat"})
+	reserved = append(reserved, Aliases{"until"})
+	reserved = append(reserved, Aliases{"read"})
+	reserved = append(reserved, Aliases{"write"})
+	reserved = append(reserved, Aliases{"while"})
+	reserved = append(reserved, Aliases{"do"})
+	reserved = append(reserved, Aliases{"procedure"})
+	reserved = append(reserved, Aliases{"return"})
+	reserved = append(reserved, Aliases{"and"})
+	reserved = append(reserved, Aliases{"or"})
+	reserved = append(reserved, Aliases{"not"})
+	reserved = append(reserved, Aliases{"array"})
+	reserved = append(reserved, Aliases{"length"})
+	reserved = append(reserved, Aliases{"true"})
+	reserved = append(reserved, Aliases{"false"})
 
 	Locale.ReservedArray = reserved
 
+	Locale.DecimalSeparator = "."
+	Locale.NativeDigits = ""
+
+	Locale.DiagnosticError = "error"
+	Locale.DiagnosticWarning = "warning"
+
+	Locale.ConfigFileError = "Cannot read configuration file %s"
+	Locale.LocaleReservedLengthError = "Configuration file must contain localizations for %d key words."
+	Locale.LocaleNotFoundError = "Cannot find localization %s"
+	Locale.ArgumentValueError = "Invalid value %s for option %s"
+	Locale.WriteFileError = "Cannot write file %s"
+	Locale.BytecodeFormatError = "%s is not a valid MLPL bytecode file"
+	Locale.BytecodeVersionError = "%s has bytecode version %d, this interpreter runs version %d"
+
+	Locale.LocaleSyntaxError = "Configuration file is not valid: %s"
+	Locale.LocaleUnknownKeyError = "Unknown key %s"
+	Locale.LocaleMissingKeyError = "Missing key %s"
+	Locale.LocaleIdentifierError = "Keyword %s for %s is not a valid identifier"
+	Locale.LocaleDuplicateKeywordError = "Word %s is used for both %s and %s"
+	Locale.LocaleCaseCollisionWarning = "Words %s for %s and %s for %s differ only in letter case"
+	Locale.LocaleVerbError = "Message %s has the verbs %v, the English message has %v"
+	Locale.LocaleExtendsCycleError = "Localization %s extends itself"
+	Locale.LocaleFallbackWarning = "Key %s is taken from %s"
+	Locale.LocaleKeywordMissingError = "There is no keyword for %s"
+	Locale.LocaleNativeDigitsError = "Native digits %s are not the ten decimal digits from zero to nine"
+
 	Locale.ParseError = "Scanner bug: state= %d\n"
+	Locale.ParseFileError = "Cannot read code file %s"
 
-	Locale.LexerSyntaxError = "Syntax error at line %d, unexpected token -> "
+	Locale.LexerSyntaxError = "Syntax error, unexpected token -> %s"
 	Locale.LexerReservedWordError = "reserved word: %s\n"
 	Locale.LexerAssignError = ":=\n"
 	Locale.LexerLTError = "<\n"
+	Locale.LexerGTError = ">\n"
+	Locale.LexerLEError = "<=\n"
+	Locale.LexerGEError = ">=\n"
+	Locale.LexerNEError = "<>\n"
 	Locale.LexerEQError = "=\n"
 	Locale.LexerLPARENError = "(\n"
 	Locale.LexerRPARENError = ")\n"
+	Locale.LexerLBRACKETError = "[\n"
+	Locale.LexerRBRACKETError = "]\n"
 	Locale.LexerSEMIError = ";\n"
+	Locale.LexerCOMMAError = ",\n"
 	Locale.LexerPLUSError = "+\n"
 	Locale.LexerMINUSError = "-\n"
 	Locale.LexerTIMESError = "*\n"
@@ -117,14 +304,30 @@ func init() {
 	Locale.LexerIDError = "ID, name= %s\n"
 	Locale.LexerERRORError = "ERROR: %s\n"
 	Locale.LexerDEFAULTError = "Unknown token: %d\n"
-	Locale.LexerABORTINGError = "Aborting\n"
 
-	Locale.AnalyzeTypePrefixError = "Type error at line %d: %s\n"
 	Locale.AnalyzeTypeOpError = "Op applied to non-integer"
+	Locale.AnalyzeTypeLogicError = "logical operator applied to non-Boolean"
 	Locale.AnalyzeTypeIfError = "if test is not Boolean"
-	Locale.AnalyzeTypeAssignError = "assignment of non-integer value"
-	Locale.AnalyzeTypeWriteError = "write of non-integer or non-string value"
+	Locale.AnalyzeTypeAssignError = "assignment of value that is not a number, string or Boolean"
+	Locale.AnalyzeTypeMismatchError = "variable %s already holds a value of another type"
+	Locale.AnalyzeTypeReadError = "read into a Boolean variable"
+	Locale.AnalyzeTypeWriteError = "write of value that is not a number, string or Boolean"
 	Locale.AnalyzeTypeRepeatError = "repeat test is not Boolean"
+	Locale.AnalyzeTypeWhileError = "while test is not Boolean"
+	Locale.AnalyzeTypeArgumentError = "procedure argument is not an integer"
+	Locale.AnalyzeTypeReturnError = "return of non-integer value"
+	Locale.AnalyzeTypeIndexError = "array index is not an integer"
+	Locale.AnalyzeProcRedefinedError = "procedure %s is already defined"
+	Locale.AnalyzeProcParamError = "parameter %s is repeated"
+	Locale.AnalyzeProcUndefinedError = "call of undefined procedure %s"
+	Locale.AnalyzeProcArgumentsError = "wrong number of arguments in call of %s"
+	Locale.AnalyzeProcNameError = "%s is a procedure, not a variable"
+	Locale.AnalyzeReturnError = "return outside of a procedure"
+	Locale.AnalyzeArrayDeclarationError = "array %s must be declared before it is used"
+	Locale.AnalyzeArraySizeError = "array length must be a positive number"
+	Locale.AnalyzeArrayMemoryError = "array %s of length %d does not fit in memory, %d cells are free"
+	Locale.AnalyzeArrayIndexMissingError = "%s is an array, an index is missing"
+	Locale.AnalyzeNotArrayError = "%s is not an array"
 
 	Locale.CodegenUnknownOperatorError = "Unknown operator for code generation"
 	Locale.CodegenUnknownTypeError = "Unknown type for code generation"
@@ -141,26 +344,441 @@ func init() {
 	Locale.VmInvalidProgramCounterError = "Invalid program counter value: %d\n"
 	Locale.VmInvalidMemoryAddressError = "Invalid memory address value: %d\n"
 	Locale.VmNonIntegerEnteredError = "Non integer entered."
+	Locale.VmNonNumberEnteredError = "Non number entered."
+	Locale.VmEndOfInputError = "No more input."
 	Locale.VmDivisionWIthZeroError = "Division with zero."
+	Locale.VmIndexOutOfRangeError = "Index %d is out of range, array length is %d.\n"
+	Locale.VmCancelledError = "Program was cancelled."
+	Locale.VmStepLimitError = "Program stopped after executing %d instructions.\n"
+	Locale.VmTimeoutError = "Program stopped after running for %s.\n"
+	Locale.VmStackOverflowError = "Stack overflow, procedure calls are nested too deeply."
+	Locale.VmInvalidRegisterError = "Invalid register %d on location %d and line: %d\n"
+	Locale.VmInvalidStringError = "Invalid string %d."
+
+	Locale.AsmUnknownLabelError = "unknown label %s"
+	Locale.AsmDuplicateLabelError = "label %s is defined again"
+	Locale.AsmDuplicateLocationError = "location %d is used again"
+	Locale.AsmMemoryLocationError = "invalid memory location %s"
+	Locale.AsmMissingOpcodeError = "missing opcode on location %d"
+	Locale.AsmInvalidOpcodeError = "invalid opcode on location %d"
+	Locale.AsmInvalidNumberOfArgumentsError = "invalid number of arguments on location %d"
+	Locale.AsmInvalidFirstArgumentError = "invalid first argument on location %d"
+	Locale.AsmInvalidSecondArgumentError = "invalid second argument on location %d"
+	Locale.AsmInvalidThirdArgumentError = "invalid third argument on location %d"
+	Locale.AsmInvalidRegisterError = "invalid register %d on location %d"
+	Locale.AsmLineComment = "line %d"
+
+	Locale.DebugPrompt = "(debug) "
+	Locale.DebugStepCommand = "step"
+	Locale.DebugBreakCommand = "break"
+	Locale.DebugContinueCommand = "continue"
+	Locale.DebugPrintCommand = "print"
+	Locale.DebugQuitCommand = "quit"
+	Locale.DebugStoppedMessage = "Line %d: %s\n"
+	Locale.DebugBreakpointMessage = "Breakpoint set on line %d\n"
+	Locale.DebugValueMessage = "%s = %s\n"
+	Locale.DebugFinishedMessage = "Program finished\n"
+	Locale.DebugNoStatementError = "There is no statement on line %d\n"
+	Locale.DebugUnknownVariableError = "Unknown variable %s\n"
+	Locale.DebugUnknownCommandError = "Unknown command %s, commands are step, break <line>, continue, print <name> and quit\n"
+
+	Locale.TranslateKeywordWarning = "identifier %s is a keyword in the target language"
+
+	Locale.Name = "english"
+
+	defaults = *Locale
+}
+
+// Function New returns a copy of the English locale, configurations loaded into it keep English for the keys they leave out
+func New() *LocaleType {
+	loc := defaults
+	loc.ReservedArray = make([]Aliases, 0, len(defaults.ReservedArray))
+	for _, aliases := range defaults.ReservedArray {
+		loc.ReservedArray = append(loc.ReservedArray, append(Aliases(nil), aliases...))
+	}
+	loc.AssembleReserved()
+
+	return &loc
+}
+
+func AssembleReserved() []types.Diagnostic {
+	return Locale.AssembleReserved()
+}
+
+// Function AssembleReserved maps every spelling of the keywords of a locale to its token type
+func (loc *LocaleType) AssembleReserved() []types.Diagnostic {
+	if len(loc.ReservedArray) != ReservedLength {
+		return []types.Diagnostic{{Severity: types.ErrorSeverity, Key: "LocaleReservedLengthError", Args: []interface{}{ReservedLength}}}
+	}
+
+	reserved := make(map[string]types.TokenType)
+	for index, aliases := range loc.ReservedArray {
+		if len(aliases) == 0 {
+			return []types.Diagnostic{{Severity: types.ErrorSeverity, Key: "LocaleKeywordMissingError", Args: []interface{}{defaults.ReservedArray[index][0]}}}
+		}
+		for _, alias := range aliases {
+			reserved[norm.NFC.String(alias)] = reservedTypes[index]
+		}
+	}
+
+	loc.Reserved = reserved
+
+	return nil
+}
+
+// Function Resolve returns the locale name for a name or language code
+func Resolve(name string) string {
+	name = strings.ToLower(name)
+	if full, ok := languages[name]; ok {
+		return full
+	}
+
+	return name
+}
+
+// Function Code returns the language code of a locale name, empty when it has none
+func Code(name string) string {
+	for code, full := range languages {
+		if full == name {
+			return code
+		}
+	}
+
+	return ""
+}
+
+// Function Pragma returns the first line of a code file that asks for a locale
+func Pragma(name string) string {
+	return pragma + " " + name + " #"
+}
+
+// Function PragmaName returns the locale a line asks for as #! locale: name #, empty when the line is not a pragma
+func PragmaName(line string) string {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+	if !strings.HasPrefix(line, pragma) || !strings.HasSuffix(line, "#") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, pragma), "#"))
+}
+
+// Function Bundled returns the names of the locales built into the interpreter in alphabetical order
+func Bundled() []string {
+	files, _ := fs.Glob(bundled, "localization/*.cfg")
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(path.Base(file), ".cfg"))
+	}
+
+	return names
+}
+
+// Function BundledConfig returns the configuration of a bundled locale given by name or language code
+func BundledConfig(name string) ([]byte, bool) {
+	config, err := bundled.ReadFile("localization/" + Resolve(name) + ".cfg")
+
+	return config, err == nil
+}
+
+// Function isName reports whether a locale name is a plain file name, without path separators or parent directory references
+func isName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..")
+}
+
+/*
+Function Find returns the configuration of a locale given by name or language code with the file it comes from.
+Configuration files in the directories listed in MLPL_LOCALE_PATH come before the locales built into the interpreter.
+It returns false when there is no such locale, or when the name could reach outside those directories.
+*/
+func Find(name string) (string, []byte, bool) {
+	if !isName(name) {
+		return Resolve(name) + ".cfg", nil, false
+	}
+
+	for _, dir := range filepath.SplitList(os.Getenv("MLPL_LOCALE_PATH")) {
+		configFile := filepath.Join(dir, Resolve(name)+".cfg")
+		if config, err := ioutil.ReadFile(configFile); err == nil {
+			return configFile, config, true
+		}
+	}
+
+	config, ok := BundledConfig(name)
+
+	return Resolve(name) + ".cfg", config, ok
+}
+
+// Function ConfigPosition returns the line and column of a byte offset in a configuration
+func ConfigPosition(config []byte, offset int) (int, int) {
+	if offset > len(config) {
+		offset = len(config)
+	}
+	lineStart := bytes.LastIndexByte(config[:offset], '\n') + 1
+
+	return bytes.Count(config[:offset], []byte{'\n'}) + 1, utf8.RuneCount(config[lineStart:offset]) + 1
+}
+
+// Function KeyOffset returns the byte offset of a key in a configuration and the offset just past its colon, -1 when the key is not there
+func KeyOffset(config []byte, key string) (int, int) {
+	location := regexp.MustCompile(`"` + regexp.QuoteMeta(key) + `"\s*:`).FindIndex(config)
+	if location == nil {
+		return -1, -1
+	}
+
+	return location[0], location[1]
 }
 
-func AssembleReserved() {
-	if len(Locale.ReservedArray) != ReservedLength {
-		errorMessage := fmt.Sprintf("Configuration file must contain localizations for eight key word.\n")
-		err := errors.New(errorMessage)
-		panic(err)
+/*
+Function JSONError returns the diagnostic of a configuration file that is not valid JSON or has a value of the wrong type.
+The diagnostic points at the problem when the json package tells where it is.
+*/
+func JSONError(configFile string, config []byte, err error) types.Diagnostic {
+	diagnostic := types.Diagnostic{File: configFile, Severity: types.ErrorSeverity, Key: "LocaleSyntaxError", Args: []interface{}{err.Error()}}
+
+	switch jsonErr := err.(type) {
+	case *json.SyntaxError:
+		// The offset is just past the byte json could not read
+		diagnostic.Line, diagnostic.Column = ConfigPosition(config, int(jsonErr.Offset)-1)
+	case *json.UnmarshalTypeError:
+		diagnostic.Line, diagnostic.Column = ConfigPosition(config, int(jsonErr.Offset))
+	}
+
+	return diagnostic
+}
+
+// Function ExtendsOf returns the locale a configuration extends, empty when it extends none or cannot be read
+func ExtendsOf(config []byte) string {
+	var header struct{ Extends string }
+	json.Unmarshal(config, &header)
+
+	return header.Extends
+}
+
+/*
+Function Load reads a configuration into a locale and assembles its reserved words.
+A configuration that extends another locale is read over that locale, so the keys it leaves out keep the values of its parent.
+*/
+func (loc *LocaleType) Load(configFile string, config []byte) []types.Diagnostic {
+	seen := map[string]bool{strings.TrimSuffix(filepath.Base(configFile), ".cfg"): true}
+
+	return loc.load(configFile, config, seen)
+}
+
+// Function load reads a configuration after its ancestors, seen holds the locales already on the way to catch a locale that extends itself
+func (loc *LocaleType) load(configFile string, config []byte, seen map[string]bool) []types.Diagnostic {
+	var header struct{ Extends string }
+	if err := json.Unmarshal(config, &header); err != nil {
+		return []types.Diagnostic{JSONError(configFile, config, err)}
+	}
+
+	if header.Extends != "" {
+		diagnostic := types.Diagnostic{File: configFile, Severity: types.ErrorSeverity}
+		if offset, _ := KeyOffset(config, "extends"); offset >= 0 {
+			diagnostic.Line, diagnostic.Column = ConfigPosition(config, offset)
+		}
+
+		name := Resolve(header.Extends)
+		parentFile, parent, found := Find(name)
+		switch {
+		case seen[name]:
+			diagnostic.Key, diagnostic.Args = "LocaleExtendsCycleError", []interface{}{name}
+			return []types.Diagnostic{diagnostic}
+		case !found:
+			diagnostic.Key, diagnostic.Args = "LocaleNotFoundError", []interface{}{header.Extends}
+			return []types.Diagnostic{diagnostic}
+		}
+
+		seen[name] = true
+		if diagnostics := loc.load(parentFile, parent, seen); len(diagnostics) > 0 {
+			return diagnostics
+		}
+	}
+
+	if err := json.Unmarshal(config, loc); err != nil {
+		return []types.Diagnostic{JSONError(configFile, config, err)}
+	}
+	loc.Name = strings.TrimSuffix(filepath.Base(configFile), ".cfg")
+
+	diagnostics := loc.AssembleReserved()
+	for index := range diagnostics {
+		diagnostics[index].File = configFile
+	}
+
+	return diagnostics
+}
+
+// Function digitValue returns the value of a Unicode decimal digit, every script has its digits in one run from zero to nine
+func digitValue(r rune) int {
+	for _, digits := range unicode.Nd.R16 {
+		if rune(digits.Lo) <= r && r <= rune(digits.Hi) {
+			return int(r-rune(digits.Lo)) % 10
+		}
+	}
+	for _, digits := range unicode.Nd.R32 {
+		if rune(digits.Lo) <= r && r <= rune(digits.Hi) {
+			return int(r-rune(digits.Lo)) % 10
+		}
+	}
+
+	return -1
+}
+
+// Function ASCIIDigits returns a text with every Unicode decimal digit replaced by the ASCII digit of the same value
+func ASCIIDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII && unicode.IsDigit(r) {
+			return '0' + rune(digitValue(r))
+		}
+		return r
+	}, s)
+}
+
+// Function ValidNativeDigits reports whether native digits are empty or the ten decimal digits of one script in order
+func ValidNativeDigits(digits string) bool {
+	runes := []rune(digits)
+	if len(runes) == 0 {
+		return true
+	}
+	if len(runes) != 10 {
+		return false
+	}
+
+	for value, r := range runes {
+		if !unicode.IsDigit(r) || digitValue(r) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Function WriteDigits returns a text with its ASCII digits replaced by the native digits of the locale, unchanged when the locale has none
+func (loc *LocaleType) WriteDigits(s string) string {
+	native := []rune(loc.NativeDigits)
+	if len(native) != 10 || !ValidNativeDigits(loc.NativeDigits) {
+		return s
 	}
 
-	reserved := make([]types.ReservedWord, 0, ReservedLength)
+	return strings.Map(func(r rune) rune {
+		if '0' <= r && r <= '9' {
+			return native[r-'0']
+		}
+		return r
+	}, s)
+}
+
+/*
+Function RuntimeConfig returns a configuration with just the keys a compiled program needs while it runs.
+These are the messages of the machine and of diagnostics, and the way numbers are read and written.
+*/
+func (loc *LocaleType) RuntimeConfig() []byte {
+	config := make(map[string]string)
+	value := reflect.ValueOf(loc).Elem()
+	for index := 0; index < value.NumField(); index++ {
+		name := value.Type().Field(index).Name
+		if strings.HasPrefix(name, "Vm") || strings.HasPrefix(name, "Diagnostic") || name == "DecimalSeparator" || name == "NativeDigits" {
+			config[strings.ToLower(name[:1])+name[1:]] = value.Field(index).String()
+		}
+	}
+
+	data, _ := json.MarshalIndent(config, "", "\t")
 
-	reserved = append(reserved, types.ReservedWord{types.IF, Locale.ReservedArray[0]})
-	reserved = append(reserved, types.ReservedWord{types.THEN, Locale.ReservedArray[1]})
-	reserved = append(reserved, types.ReservedWord{types.ELSE, Locale.ReservedArray[2]})
-	reserved = append(reserved, types.ReservedWord{types.END, Locale.ReservedArray[3]})
-	reserved = append(reserved, types.ReservedWord{types.REPEAT, Locale.ReservedArray[4]})
-	reserved = append(reserved, types.ReservedWord{types.UNTIL, Locale.ReservedArray[5]})
-	reserved = append(reserved, types.ReservedWord{types.READ, Locale.ReservedArray[6]})
-	reserved = append(reserved, types.ReservedWord{types.WRITE, Locale.ReservedArray[7]})
+	return data
+}
+
+// Function Keyword returns the localized spelling of a reserved word
+func Keyword(tokenType types.TokenType) string {
+	return Locale.Keyword(tokenType)
+}
+
+// Function Keyword returns the first spelling of a reserved word in a locale, empty when the token type is not a reserved word
+func (loc *LocaleType) Keyword(tokenType types.TokenType) string {
+	for index, reservedType := range reservedTypes {
+		if reservedType == tokenType && index < len(loc.ReservedArray) && len(loc.ReservedArray[index]) > 0 {
+			return loc.ReservedArray[index][0]
+		}
+	}
+
+	return ""
+}
+
+// Function Lookup returns the token type of any spelling of a reserved word in a locale, ID for any other word
+func (loc *LocaleType) Lookup(s string) types.TokenType {
+	if tokenType, ok := loc.Reserved[norm.NFC.String(s)]; ok {
+		return tokenType
+	}
+
+	return types.ID
+}
+
+// Function Message returns the localized text of a diagnostic, the key itself when the locale has no such message
+func Message(diagnostic types.Diagnostic) string {
+	return Locale.Message(diagnostic)
+}
+
+// Function Message returns the text of a diagnostic in a locale, the key itself when the locale has no such message
+func (loc *LocaleType) Message(diagnostic types.Diagnostic) string {
+	field := reflect.ValueOf(loc).Elem().FieldByName(diagnostic.Key)
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return diagnostic.Key
+	}
+
+	return strings.TrimRight(fmt.Sprintf(field.String(), diagnostic.Args...), "\n")
+}
+
+// Function Describe formats a diagnostic as file:line:column: severity: message, leaving out the parts that are not known
+func Describe(diagnostic types.Diagnostic) string {
+	return Locale.Describe(diagnostic)
+}
+
+// Function Describe formats a diagnostic in a locale as file:line:column: severity: message, leaving out the parts that are not known
+func (loc *LocaleType) Describe(diagnostic types.Diagnostic) string {
+	var position string = diagnostic.File
+
+	if diagnostic.Line > 0 {
+		if position != "" {
+			position += ":"
+		}
+		position += fmt.Sprintf("%d", diagnostic.Line)
+		if diagnostic.Column > 0 {
+			position += fmt.Sprintf(":%d", diagnostic.Column)
+		}
+	}
+
+	severity := loc.DiagnosticError
+	if diagnostic.Severity == types.WarningSeverity {
+		severity = loc.DiagnosticWarning
+	}
+
+	if position == "" {
+		return fmt.Sprintf("%s: %s", severity, loc.Message(diagnostic))
+	}
+
+	return fmt.Sprintf("%s: %s: %s", position, severity, loc.Message(diagnostic))
+}
+
+/*
+Function Excerpt returns the source line a diagnostic points at with a caret under its column.
+It returns an empty string when the diagnostic has no column or its line is not among lines.
+*/
+func Excerpt(diagnostic types.Diagnostic, lines []string) string {
+	if diagnostic.Column < 1 || diagnostic.Line < 1 || diagnostic.Line > len(lines) {
+		return ""
+	}
+
+	line := strings.TrimRight(lines[diagnostic.Line-1], "\r")
+
+	// Tabs are kept so the caret lines up however wide the terminal shows them
+	var marker []rune
+	for index, r := range []rune(line) {
+		if index >= diagnostic.Column-1 {
+			break
+		}
+		if r == '\t' {
+			marker = append(marker, '\t')
+		} else {
+			marker = append(marker, ' ')
+		}
+	}
 
-	Locale.Reserved = reserved
+	return line + "\n" + string(marker) + "^"
 }
@@ -25,25 +25,342 @@ SOFTWARE.
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/ivandejanovic/mlpl/analyze"
+	"github.com/ivandejanovic/mlpl/bytecode"
 	"github.com/ivandejanovic/mlpl/cfg"
 	"github.com/ivandejanovic/mlpl/codegen"
+	"github.com/ivandejanovic/mlpl/debug"
 	"github.com/ivandejanovic/mlpl/lexer"
+	"github.com/ivandejanovic/mlpl/locale"
 	"github.com/ivandejanovic/mlpl/parse"
+	"github.com/ivandejanovic/mlpl/repl"
+	"github.com/ivandejanovic/mlpl/tm"
+	"github.com/ivandejanovic/mlpl/translate"
+	"github.com/ivandejanovic/mlpl/types"
+	"github.com/ivandejanovic/mlpl/validate"
 	"github.com/ivandejanovic/mlpl/vm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
+/*
+Function report prints diagnostics to standard error in line order and tells whether any of them is an error.
+Diagnostics with a column are followed by their line from lines with a caret under the column.
+*/
+func report(file string, lines []string, diagnostics []types.Diagnostic) bool {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+	errors := false
+	for _, diagnostic := range diagnostics {
+		errors = errors || diagnostic.Severity == types.ErrorSeverity
+		if diagnostic.File == "" {
+			diagnostic.File = file
+		}
+		fmt.Fprintln(os.Stderr, locale.Describe(diagnostic))
+		if excerpt := locale.Excerpt(diagnostic, lines); excerpt != "" {
+			fmt.Fprintln(os.Stderr, excerpt)
+		}
+	}
+
+	return errors
+}
+
+/*
+Function compile translates a code file to TM code and returns the line table and symbol table the debugger needs.
+It returns false when any stage reported a problem.
+*/
+func compile(codeFile string, lines []string) ([]tm.Instruction, map[int]codegen.Statement, map[string]types.Bucket, bool) {
+	tokens, diagnostics := parse.Parse(codeFile)
+	if report(codeFile, nil, diagnostics) {
+		return nil, nil, nil, false
+	}
+
+	// Statements that parsed are checked even after syntax errors, so one run reports as many errors as possible
+	treeNode, diagnostics := lexer.Lex(tokens, locale.Locale)
+	bucketMap, symtabDiagnostics := analyze.BuildSymtab(treeNode)
+	diagnostics = append(diagnostics, symtabDiagnostics...)
+	diagnostics = append(diagnostics, analyze.TypeCheck(treeNode, bucketMap)...)
+	if report(codeFile, lines, diagnostics) {
+		return nil, nil, nil, false
+	}
+
+	gen := codegen.NewGenerator(locale.Locale)
+	code, _, diagnostics := gen.Generate(treeNode, bucketMap)
+	if report(codeFile, lines, diagnostics) {
+		return nil, nil, nil, false
+	}
+
+	return code, gen.LineTable(), bucketMap, true
+}
+
+// Function writeListing writes the assembly listing of a program when the arguments ask for one. It returns false when the file could not be written.
+func writeListing(arguments cfg.Arguments, code []tm.Instruction) bool {
+	if arguments.Listing == "" {
+		return true
+	}
+
+	listing := strings.Join(tm.Listing(code), "\n") + "\n"
+	if err := ioutil.WriteFile(arguments.Listing, []byte(listing), 0644); err != nil {
+		return !report(arguments.Listing, nil, []types.Diagnostic{{Severity: types.ErrorSeverity, Key: "WriteFileError", Args: []interface{}{arguments.Listing}}})
+	}
+
+	return true
+}
+
+/*
+Function build compiles a code file into a bytecode file that runs without the source and the locale configuration.
+It returns false when any stage reported a problem or the file could not be written.
+*/
+func build(arguments cfg.Arguments) bool {
+	codeFile := arguments.CodeFile
+	source, _ := ioutil.ReadFile(codeFile)
+	lines := strings.Split(string(source), "\n")
+
+	code, lineTable, _, ok := compile(codeFile, lines)
+	if !ok || !writeListing(arguments, code) {
+		return false
+	}
+
+	output := arguments.Output
+	if output == "" {
+		output = strings.TrimSuffix(codeFile, filepath.Ext(codeFile)) + bytecode.Extension
+	}
+
+	var buf bytes.Buffer
+	err := bytecode.Write(&buf, &bytecode.Program{Code: code, Locale: locale.Locale, Lines: lineTable})
+	if err == nil {
+		err = ioutil.WriteFile(output, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		return !report(output, nil, []types.Diagnostic{{Severity: types.ErrorSeverity, Key: "WriteFileError", Args: []interface{}{output}}})
+	}
+
+	return true
+}
+
+// Function runBytecode runs a program read from a bytecode file within the limits. It returns false when the file is not valid or the program failed.
+func runBytecode(arguments cfg.Arguments, data []byte) bool {
+	program, diagnostics := bytecode.Load(arguments.CodeFile, data)
+	if report(arguments.CodeFile, nil, diagnostics) {
+		return false
+	}
+
+	// The program reports its runtime errors in the locale it was built in
+	locale.Locale = program.Locale
+
+	return !report(arguments.CodeFile, nil, vm.Run(context.Background(), program.Code, program.Lines, os.Stdin, os.Stdout, program.Locale, arguments.Limits))
+}
+
+// Function readFailed reports a code file that could not be read and returns false
+func readFailed(codeFile string) bool {
+	return !report(codeFile, nil, []types.Diagnostic{{File: codeFile, Severity: types.ErrorSeverity, Key: "ParseFileError", Args: []interface{}{codeFile}}})
+}
+
+// Function assemble runs a program written in Tiny Machine assembly within the limits. It returns false when the file could not be assembled or the program failed.
+func assemble(arguments cfg.Arguments) bool {
+	source, err := ioutil.ReadFile(arguments.CodeFile)
+	if err != nil {
+		return readFailed(arguments.CodeFile)
+	}
+
+	code, diagnostics := tm.Assemble(arguments.CodeFile, bytes.NewReader(source))
+	if report(arguments.CodeFile, nil, diagnostics) {
+		return false
+	}
+
+	return !report(arguments.CodeFile, nil, vm.Run(context.Background(), code, nil, os.Stdin, os.Stdout, locale.Locale, arguments.Limits))
+}
+
+/*
+Function annotations returns the comments of a listing, the source line above the code of each statement and the name of each procedure above its code.
+Without the source lines only the line numbers are shown.
+*/
+func annotations(lineTable map[int]codegen.Statement, lines []string) map[int][]string {
+	locs := make([]int, 0, len(lineTable))
+	for loc := range lineTable {
+		locs = append(locs, loc)
+	}
+	sort.Ints(locs)
+
+	comments := make(map[int][]string)
+	proc, line := "", 0
+	for _, loc := range locs {
+		statement := lineTable[loc]
+		if statement.Proc != proc && statement.Proc != "" {
+			comments[loc] = append(comments[loc], locale.Locale.Keyword(types.PROCEDURE)+" "+statement.Proc)
+		}
+		proc = statement.Proc
+
+		// Statements sharing a line show it once
+		if statement.Line == line {
+			continue
+		}
+		line = statement.Line
+
+		comment := fmt.Sprintf(locale.Locale.AsmLineComment, line)
+		if line > 0 && line <= len(lines) {
+			comment += ": " + strings.TrimSpace(lines[line-1])
+		}
+		comments[loc] = append(comments[loc], comment)
+	}
+
+	return comments
+}
+
+/*
+Function disassemble writes the annotated assembly listing of a code file or a bytecode file to standard output or the output file.
+The listing can be assembled again. It returns false when the file could not be compiled or read, or the listing could not be written.
+*/
+func disassemble(arguments cfg.Arguments) bool {
+	codeFile := arguments.CodeFile
+	source, err := ioutil.ReadFile(codeFile)
+	if err != nil {
+		return readFailed(codeFile)
+	}
+
+	var code []tm.Instruction
+	var lineTable map[int]codegen.Statement
+	var lines []string
+	if bytecode.Is(source) {
+		program, diagnostics := bytecode.Load(codeFile, source)
+		if report(codeFile, nil, diagnostics) {
+			return false
+		}
+		code, lineTable = program.Code, program.Lines
+	} else {
+		var ok bool
+		lines = strings.Split(string(source), "\n")
+		if code, lineTable, _, ok = compile(codeFile, lines); !ok {
+			return false
+		}
+	}
+
+	listing := strings.Join(tm.Disassemble(code, annotations(lineTable, lines)), "\n") + "\n"
+	if arguments.Output == "" {
+		fmt.Print(listing)
+		return true
+	}
+
+	if err := ioutil.WriteFile(arguments.Output, []byte(listing), 0644); err != nil {
+		return !report(arguments.Output, nil, []types.Diagnostic{{Severity: types.ErrorSeverity, Key: "WriteFileError", Args: []interface{}{arguments.Output}}})
+	}
+
+	return true
+}
+
+/*
+Function run compiles and executes a code file within the limits, or under the debugger for the debug command.
+The assembly listing is written first when the arguments ask for one, and bytecode files are run as they are.
+It returns false when any stage reported a problem.
+*/
+func run(arguments cfg.Arguments) bool {
+	codeFile := arguments.CodeFile
+
+	// The source is read on its own only to quote lines in error messages and the debugger
+	source, _ := ioutil.ReadFile(codeFile)
+	if arguments.Command != cfg.DebugCommand && bytecode.Is(source) {
+		return runBytecode(arguments, source)
+	}
+	lines := strings.Split(string(source), "\n")
+
+	code, lineTable, bucketMap, ok := compile(codeFile, lines)
+	if !ok || !writeListing(arguments, code) {
+		return false
+	}
+
+	if arguments.Command == cfg.DebugCommand {
+		return !report(codeFile, lines, debug.Run(code, bucketMap, lineTable, lines))
+	}
+
+	return !report(codeFile, lines, vm.Run(context.Background(), code, lineTable, os.Stdin, os.Stdout, locale.Locale, arguments.Limits))
+}
+
+// Function translateFile prints a code file translated to the target locale. It returns false when the file could not be read.
+func translateFile(codeFile string, target *locale.LocaleType) bool {
+	source, err := ioutil.ReadFile(codeFile)
+	if err != nil {
+		return readFailed(codeFile)
+	}
+
+	translation, diagnostics := translate.Translate(bytes.NewReader(source), locale.Locale, target)
+	if report(codeFile, strings.Split(string(source), "\n"), diagnostics) {
+		return false
+	}
+
+	fmt.Print(translation)
+
+	return true
+}
+
+// Function checkLocales validates locale configurations and reports their problems. It returns false when any of them has an error.
+func checkLocales(configs []string) bool {
+	ok := true
+	for _, nameOrFile := range configs {
+		configFile, config, diagnostics := cfg.ReadConfig(nameOrFile)
+		if len(diagnostics) == 0 {
+			diagnostics = validate.Config(configFile, config)
+		}
+		if report(configFile, strings.Split(string(config), "\n"), diagnostics) {
+			ok = false
+		}
+	}
+
+	return ok
+}
+
 func main() {
-	abort, codeFile := cfg.HandleArgs()
+	abort, arguments, diagnostics := cfg.HandleArgs()
+
+	if report(arguments.CodeFile, nil, diagnostics) {
+		os.Exit(1)
+	}
 
 	if abort {
 		return
 	}
 
-	tokens := parse.Parse(codeFile)
-	treeNode := lexer.Lex(tokens)
-	bucketMap := analyze.BuildSymtab(treeNode)
-	analyze.TypeCheck(treeNode)
-	code := codegen.CodeGen(treeNode, bucketMap)
-	vm.Execute(code)
+	switch arguments.Command {
+	case cfg.ReplCommand:
+		repl.Run(arguments.Limits)
+		return
+	case cfg.LocaleCheckCommand:
+		if !checkLocales(arguments.Configs) {
+			os.Exit(1)
+		}
+		return
+	case cfg.BuildCommand:
+		if !build(arguments) {
+			os.Exit(1)
+		}
+		return
+	case cfg.AsmCommand:
+		if !assemble(arguments) {
+			os.Exit(1)
+		}
+		return
+	case cfg.DisasmCommand:
+		if !disassemble(arguments) {
+			os.Exit(1)
+		}
+		return
+	case cfg.TranslateCommand:
+		if !translateFile(arguments.CodeFile, arguments.Target) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !run(arguments) {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,150 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Package mlpl compiles and runs MLPL programs from inside other Go programs.
+Nothing here touches the state of the command line tool, so programs in different locales can compile and run at the same time.
+*/
+package mlpl
+
+import (
+	"context"
+	"github.com/ivandejanovic/mlpl/analyze"
+	"github.com/ivandejanovic/mlpl/cfg"
+	"github.com/ivandejanovic/mlpl/codegen"
+	"github.com/ivandejanovic/mlpl/lexer"
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/parse"
+	"github.com/ivandejanovic/mlpl/tm"
+	"github.com/ivandejanovic/mlpl/types"
+	"github.com/ivandejanovic/mlpl/vm"
+	"io"
+)
+
+// LocaleType decides the keywords of a program, the numbers it reads and writes and the language of its diagnostics
+type LocaleType = locale.LocaleType
+
+// Diagnostic is a problem found while compiling or running a program, loc.Describe formats it in a locale
+type Diagnostic = types.Diagnostic
+
+// Limits ends a program that runs too long, a zero field leaves its limit out
+type Limits = vm.Limits
+
+// Program is a compiled program that can be run any number of times, also at the same time
+type Program struct {
+	code   []tm.Instruction
+	locale *LocaleType
+	lines  map[int]codegen.Statement // Positions runtime errors
+	limits Limits
+}
+
+// Function English returns a new copy of the built in English locale
+func English() *LocaleType {
+	return locale.New()
+}
+
+// Function LoadLocale returns a new locale read from a configuration file, or else from a bundled or installed locale of that name
+func LoadLocale(nameOrFile string) (*LocaleType, []Diagnostic) {
+	configFile, config, diagnostics := cfg.ReadConfig(nameOrFile)
+	if len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+
+	loc := locale.New()
+	if diagnostics := loc.Load(configFile, config); len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+
+	return loc, nil
+}
+
+// Function hasError tells whether any of the diagnostics is an error
+func hasError(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == types.ErrorSeverity {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Function Compile translates MLPL source written in a locale, English when loc is nil.
+It returns a nil program when any stage reported an error, the diagnostics may hold warnings either way.
+*/
+func Compile(src io.Reader, loc *LocaleType) (*Program, []Diagnostic) {
+	if loc == nil {
+		loc = English()
+	}
+
+	tokens, diagnostics := parse.ParseReader(src, loc)
+	if hasError(diagnostics) {
+		return nil, diagnostics
+	}
+
+	// Statements that parsed are checked even after syntax errors, so one compile reports as many errors as possible
+	treeNode, lexDiagnostics := lexer.Lex(tokens, loc)
+	diagnostics = append(diagnostics, lexDiagnostics...)
+	bucketMap, symtabDiagnostics := analyze.BuildSymtab(treeNode)
+	diagnostics = append(diagnostics, symtabDiagnostics...)
+	diagnostics = append(diagnostics, analyze.TypeCheck(treeNode, bucketMap)...)
+	if hasError(diagnostics) {
+		return nil, diagnostics
+	}
+
+	generator := codegen.NewGenerator(loc)
+	code, _, codeDiagnostics := generator.Generate(treeNode, bucketMap)
+	diagnostics = append(diagnostics, codeDiagnostics...)
+	if hasError(diagnostics) {
+		return nil, diagnostics
+	}
+
+	return &Program{code, loc, generator.LineTable(), Limits{}}, diagnostics
+}
+
+// Function WithLimits returns the same program with limits on the instructions it executes and the time it runs for
+func (program *Program) WithLimits(limits Limits) *Program {
+	return &Program{program.code, program.locale, program.lines, limits}
+}
+
+// Function Listing returns the Tiny Machine assembly of the program, one instruction per line
+func (program *Program) Listing() []string {
+	return tm.Listing(program.code)
+}
+
+// Function Locale returns the locale the program was compiled in
+func (program *Program) Locale() *LocaleType {
+	return program.locale
+}
+
+/*
+Function Run runs the program on a machine of its own, reading its input from stdin and writing its output to stdout.
+It returns the diagnostics of a runtime error, of going over a limit, or of the context being done before the program halted.
+A program stopped while it waits for input has its read interrupted before Run returns: with a read deadline when stdin has SetReadDeadline,
+or else by closing stdin when it is an io.Closer other than an *os.File, as an io.Pipe is. The read of any other stdin goes on until its next line.
+*/
+func (program *Program) Run(ctx context.Context, stdin io.Reader, stdout io.Writer) []Diagnostic {
+	return vm.Run(ctx, program.code, program.lines, stdin, stdout, program.locale, program.limits)
+}
@@ -26,12 +26,13 @@ package parse
 
 import (
 	"bufio"
-	"fmt"
 	"github.com/ivandejanovic/mlpl/locale"
 	"github.com/ivandejanovic/mlpl/types"
+	"golang.org/x/text/unicode/norm"
 	"io"
 	"os"
 	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -42,15 +43,18 @@ const (
 	numberSign rune = '#'
 	equal      rune = '='
 	lt         rune = '<'
+	gt         rune = '>'
 	plus       rune = '+'
 	minus      rune = '-'
 	times      rune = '*'
 	over       rune = '/'
 	lParen     rune = '('
 	rParen     rune = ')'
+	lBracket   rune = '['
+	rBracket   rune = ']'
 	semi       rune = ';'
+	comma      rune = ','
 	quotation  rune = '"'
-	underscore rune = '_'
 )
 
 type state int
@@ -58,53 +62,148 @@ type state int
 const (
 	start state = 1 + iota
 	inAssign
+	inLess
+	inGreater
 	inComment
+	inSpace
 	inString
 	inNum
+	inReal
 	inId
 	done
 )
 
 type parseBuffer struct {
-	lineno int
-	reader *bufio.Reader
+	lineno      int
+	column      int // Column of the last rune read, zero right after a line break
+	prevLineno  int // Position before the last rune read, restored when it is put back
+	prevColumn  int
+	reader      *bufio.Reader
+	locale      *locale.LocaleType // Locale that decides keywords and the decimal separator
+	keepTrivia  bool               // Return white space and comments as tokens, and spell every token as written in the source
+	raw         []rune             // Runes read for the current token in trivia mode
+	diagnostics []types.Diagnostic
 }
 
-func reservedLookup(s string) types.TokenType {
-	for index := 0; index < len(locale.Locale.Reserved); index++ {
-		if s == locale.Locale.Reserved[index].Str {
-			return locale.Locale.Reserved[index].TokenType
+// Procedure scanError records a problem found by the scanner at the current position
+func (buffer *parseBuffer) scanError(key string, args ...interface{}) {
+	diagnostic := types.Diagnostic{Line: buffer.lineno, Column: buffer.column, Severity: types.ErrorSeverity, Key: key, Args: args}
+	buffer.diagnostics = append(buffer.diagnostics, diagnostic)
+}
+
+// Function isPattern reports whether a rune is reserved for the syntax of programs by the Unicode identifier rules
+func isPattern(r rune) bool {
+	return unicode.In(r, unicode.Pattern_Syntax, unicode.Pattern_White_Space)
+}
+
+// Function isIdStart reports whether a rune can start an identifier, a letter or letter number as in Unicode ID_Start
+func isIdStart(r rune) bool {
+	return unicode.In(r, unicode.L, unicode.Nl, unicode.Other_ID_Start) && !isPattern(r)
+}
+
+/*
+Function isIdPart reports whether a rune can continue an identifier, as in Unicode ID_Continue.
+Besides letters these are combining marks, which many scripts need within words, digits and connectors like underscore.
+*/
+func isIdPart(r rune) bool {
+	return isIdStart(r) || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc, unicode.Other_ID_Continue) && !isPattern(r)
+}
+
+// Function IsIdentifier reports whether the scanner reads a word as a single identifier, which keywords must be
+func IsIdentifier(word string) bool {
+	for index, r := range norm.NFC.String(word) {
+		if index == 0 && !isIdStart(r) || index > 0 && !isIdPart(r) {
+			return false
 		}
 	}
 
-	return types.ID
+	return word != ""
+}
+
+// Function decimalSeparator returns the rune separating the whole and the fractional part of a number in the locale of the scanner
+func (buffer *parseBuffer) decimalSeparator() rune {
+	r, _ := utf8.DecodeRuneInString(buffer.locale.DecimalSeparator)
+	if r == utf8.RuneError {
+		return '.'
+	}
+
+	return r
+}
+
+// Function peekFraction reports whether the next runes in the source are a decimal separator and a digit without consuming them
+func (buffer *parseBuffer) peekFraction() bool {
+	bytes, _ := buffer.reader.Peek(2 * utf8.UTFMax)
+	separator, size := utf8.DecodeRune(bytes)
+	r, _ := utf8.DecodeRune(bytes[size:])
+
+	return separator == buffer.decimalSeparator() && unicode.IsDigit(r)
+}
+
+// Function readRune reads the next rune and moves the current position past it
+func (buffer *parseBuffer) readRune() (rune, error) {
+	r, _, err := buffer.reader.ReadRune()
+	if err != nil {
+		return r, err
+	}
+
+	buffer.prevLineno, buffer.prevColumn = buffer.lineno, buffer.column
+	if buffer.keepTrivia {
+		buffer.raw = append(buffer.raw, r)
+	}
+	if r == newLine {
+		buffer.lineno++
+		buffer.column = 0
+	} else {
+		buffer.column++
+	}
+
+	return r, nil
+}
+
+// Procedure unreadRune puts back the last rune read and moves the current position back to it
+func (buffer *parseBuffer) unreadRune() {
+	buffer.reader.UnreadRune()
+	buffer.lineno, buffer.column = buffer.prevLineno, buffer.prevColumn
+	if buffer.keepTrivia {
+		buffer.raw = buffer.raw[:len(buffer.raw)-1]
+	}
 }
 
 func (buffer *parseBuffer) getToken() types.Token {
 	var currentToken types.TokenType
 	var currentTokenString string
 	var currentTokenRunes []rune
+	var lineno, column int
 
+	buffer.raw = buffer.raw[:0]
 	for state := start; state != done; {
 		save := true
-		r, _, err := buffer.reader.ReadRune()
+		r, err := buffer.readRune()
 		if err != nil && err != io.EOF {
-			panic(err)
+			// Treat a failing reader like the end of the source
+			buffer.scanError("ParseFileError", err)
+			err = io.EOF
 		}
 
-		if r == newLine {
-			buffer.lineno++
-		}
 		switch state {
 		case start:
+			// The token starts at the first rune that is not white space or a comment
+			lineno, column = buffer.lineno, buffer.column
 			if unicode.IsDigit(r) {
 				state = inNum
-			} else if unicode.IsLetter(r) {
+			} else if isIdStart(r) {
 				state = inId
 			} else if r == colon {
 				state = inAssign
+			} else if r == lt {
+				state = inLess
+			} else if r == gt {
+				state = inGreater
 			} else if r == space || r == tab || r == newLine {
 				save = false
+				if buffer.keepTrivia {
+					state = inSpace
+				}
 			} else if r == numberSign {
 				save = false
 				state = inComment
@@ -120,8 +219,6 @@ func (buffer *parseBuffer) getToken() types.Token {
 					switch r {
 					case equal:
 						currentToken = types.EQ
-					case lt:
-						currentToken = types.LT
 					case plus:
 						currentToken = types.PLUS
 					case minus:
@@ -134,8 +231,14 @@ func (buffer *parseBuffer) getToken() types.Token {
 						currentToken = types.LPAREN
 					case rParen:
 						currentToken = types.RPAREN
+					case lBracket:
+						currentToken = types.LBRACKET
+					case rBracket:
+						currentToken = types.RBRACKET
 					case semi:
 						currentToken = types.SEMI
+					case comma:
+						currentToken = types.COMMA
 					default:
 						currentToken = types.ERROR
 					}
@@ -146,11 +249,32 @@ func (buffer *parseBuffer) getToken() types.Token {
 			if err == io.EOF {
 				state = done
 				currentToken = types.ENDFILE
+				if buffer.keepTrivia {
+					currentToken = types.COMMENT
+				}
 			} else if r == numberSign {
 				state = start
+				if buffer.keepTrivia {
+					state = done
+					currentToken = types.COMMENT
+				}
+			}
+		case inSpace:
+			save = false
+			if err == io.EOF || !(r == space || r == tab || r == newLine) {
+				if err != io.EOF {
+					buffer.unreadRune()
+				}
+				state = done
+				currentToken = types.SPACE
 			}
 		case inString:
-			if r == quotation {
+			if err == io.EOF {
+				// A string left open at the end of the source
+				save = false
+				state = done
+				currentToken = types.ERROR
+			} else if r == quotation {
 				save = false
 				state = done
 				currentToken = types.STRING
@@ -162,30 +286,70 @@ func (buffer *parseBuffer) getToken() types.Token {
 			if r == equal {
 				currentToken = types.ASSIGN
 			} else {
-				err = buffer.reader.UnreadRune()
-				if err != nil {
-					panic(err)
+				if err != io.EOF {
+					buffer.unreadRune()
 				}
 				save = false
 				currentToken = types.ERROR
 			}
+		case inLess:
+			state = done
+			if r == equal {
+				currentToken = types.LE
+			} else if r == gt {
+				currentToken = types.NE
+			} else {
+				if err != io.EOF {
+					buffer.unreadRune()
+				}
+				save = false
+				currentToken = types.LT
+			}
+		case inGreater:
+			state = done
+			if r == equal {
+				currentToken = types.GE
+			} else {
+				if err != io.EOF {
+					buffer.unreadRune()
+				}
+				save = false
+				currentToken = types.GT
+			}
 		case inNum:
-			if !unicode.IsDigit(r) {
+			// A separator only continues the number when a digit follows it, so 1,5 is a real while 1, 5 are two numbers.
+			// The separator is put back before peeking, the reader can only put back a rune right after reading it.
+			if r == buffer.decimalSeparator() && err != io.EOF {
+				buffer.unreadRune()
+				if buffer.peekFraction() {
+					buffer.readRune()
+					state = inReal
+				} else {
+					save = false
+					state = done
+					currentToken = types.NUM
+				}
+			} else if !unicode.IsDigit(r) {
 				if err != io.EOF {
-					err = buffer.reader.UnreadRune()
-					if err != nil {
-						panic(err)
-					}
+					buffer.unreadRune()
 				}
 				save = false
 				state = done
 				currentToken = types.NUM
 			}
+		case inReal:
+			if !unicode.IsDigit(r) {
+				if err != io.EOF {
+					buffer.unreadRune()
+				}
+				save = false
+				state = done
+				currentToken = types.REAL
+			}
 		case inId:
-			if !(unicode.IsLetter(r) || r == underscore) {
-				err = buffer.reader.UnreadRune()
-				if err != nil {
-					panic(err)
+			if !isIdPart(r) {
+				if err != io.EOF {
+					buffer.unreadRune()
 				}
 				save = false
 				state = done
@@ -193,12 +357,12 @@ func (buffer *parseBuffer) getToken() types.Token {
 			}
 		case done:
 			//Should never happen
-			fmt.Printf(locale.Locale.ParseError, state)
+			buffer.scanError("ParseError", state)
 			state = done
 			currentToken = types.ERROR
 		default:
 			//Should never happen
-			fmt.Printf(locale.Locale.ParseError, state)
+			buffer.scanError("ParseError", state)
 			state = done
 			currentToken = types.ERROR
 		}
@@ -209,24 +373,51 @@ func (buffer *parseBuffer) getToken() types.Token {
 		if state == done {
 			currentTokenString = string(currentTokenRunes)
 			if currentToken == types.ID {
-				currentToken = reservedLookup(currentTokenString)
+				// Differently composed spellings of a word are the same identifier
+				currentTokenString = norm.NFC.String(currentTokenString)
+				currentToken = buffer.locale.Lookup(currentTokenString)
+			}
+			if buffer.keepTrivia {
+				currentTokenString = string(buffer.raw)
 			}
 		}
 	}
 
-	return types.Token{currentToken, currentTokenString, buffer.lineno}
+	return types.Token{TokenType: currentToken, TokenString: currentTokenString, Lineno: lineno, Column: column}
 }
 
-func Parse(sourceFile string) []types.Token {
-	var tokens []types.Token
-
+func Parse(sourceFile string) ([]types.Token, []types.Diagnostic) {
 	source, err := os.Open(sourceFile)
 	if err != nil {
-		panic(err)
+		return nil, []types.Diagnostic{{File: sourceFile, Severity: types.ErrorSeverity, Key: "ParseFileError", Args: []interface{}{sourceFile}}}
 	}
 
-	reader := bufio.NewReader(source)
-	buffer := &parseBuffer{0, reader}
+	defer source.Close()
+
+	return ParseReader(source, locale.Locale)
+}
+
+// Function ParseReader scans all tokens from a reader with the keywords of loc, used for source that does not come from a file
+func ParseReader(source io.Reader, loc *locale.LocaleType) ([]types.Token, []types.Diagnostic) {
+	buffer := &parseBuffer{lineno: 1, reader: bufio.NewReader(source), locale: loc}
+
+	return buffer.scan()
+}
+
+/*
+Function ParseTrivia scans all tokens from a reader in trivia mode, with the keywords of loc.
+White space and comments come back as SPACE and COMMENT tokens and every token is spelled exactly as in the source,
+so writing out the token strings in order reproduces the source.
+*/
+func ParseTrivia(source io.Reader, loc *locale.LocaleType) ([]types.Token, []types.Diagnostic) {
+	buffer := &parseBuffer{lineno: 1, reader: bufio.NewReader(source), locale: loc, keepTrivia: true}
+
+	return buffer.scan()
+}
+
+// Function scan scans tokens up to and including ENDFILE
+func (buffer *parseBuffer) scan() ([]types.Token, []types.Diagnostic) {
+	var tokens []types.Token
 
 	for moreTokens := true; moreTokens; {
 		token := buffer.getToken()
@@ -237,7 +428,5 @@ func Parse(sourceFile string) []types.Token {
 		}
 	}
 
-	defer source.Close()
-
-	return tokens
+	return tokens, buffer.diagnostics
 }
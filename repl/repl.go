@@ -0,0 +1,168 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ivandejanovic/mlpl/analyze"
+	"github.com/ivandejanovic/mlpl/codegen"
+	"github.com/ivandejanovic/mlpl/lexer"
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/parse"
+	"github.com/ivandejanovic/mlpl/tm"
+	"github.com/ivandejanovic/mlpl/types"
+	"github.com/ivandejanovic/mlpl/vm"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	prompt         = "> "
+	continuePrompt = "... "
+)
+
+// Function openBlocks returns how many if, while, repeat and procedure blocks the tokens leave unclosed
+func openBlocks(tokens []types.Token) int {
+	var open int = 0
+
+	for _, token := range tokens {
+		switch token.TokenType {
+		case types.IF, types.WHILE, types.REPEAT, types.PROCEDURE:
+			open++
+		case types.END, types.UNTIL:
+			open--
+		}
+	}
+
+	return open
+}
+
+// Function readFragment reads lines of the session input until they form a fragment with no unclosed blocks, returning false at the end of input
+func readFragment(session *vm.Session) (string, bool) {
+	var source string
+
+	fmt.Print(prompt)
+	for {
+		line, err := session.ReadString()
+		if err != nil && line == "" {
+			return source, false
+		}
+
+		source += line
+		if !strings.HasSuffix(source, "\n") {
+			source += "\n"
+		}
+
+		tokens, _ := parse.ParseReader(strings.NewReader(source), locale.Locale)
+		if openBlocks(tokens) <= 0 {
+			return source, true
+		}
+
+		fmt.Print(continuePrompt)
+	}
+}
+
+// Function report prints diagnostics in position order with the fragment lines they point at and tells whether there were any
+func report(lines []string, diagnostics []types.Diagnostic) bool {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+	for _, diagnostic := range diagnostics {
+		fmt.Println(locale.Describe(diagnostic))
+		if excerpt := locale.Excerpt(diagnostic, lines); excerpt != "" {
+			fmt.Println(excerpt)
+		}
+	}
+
+	return len(diagnostics) > 0
+}
+
+/*
+Function compile compiles a fragment against a copy of the symbol table and returns the copy with the new symbols.
+Compilation errors are reported and leave the symbol table and generator as they were.
+*/
+func compile(source string, bucketMap map[string]types.Bucket, gen *codegen.Generator) ([]tm.Instruction, int, map[string]types.Bucket, bool) {
+	lines := strings.Split(source, "\n")
+	tokens, diagnostics := parse.ParseReader(strings.NewReader(source), locale.Locale)
+	if report(lines, diagnostics) {
+		return nil, 0, bucketMap, false
+	}
+
+	treeNode, diagnostics := lexer.Lex(tokens, locale.Locale)
+	if treeNode == nil && len(diagnostics) == 0 {
+		return nil, 0, bucketMap, false
+	}
+
+	fragmentMap := make(map[string]types.Bucket)
+	for name, bucket := range bucketMap {
+		fragmentMap[name] = bucket
+	}
+
+	_, symtabDiagnostics := analyze.ExtendSymtab(treeNode, fragmentMap)
+	diagnostics = append(diagnostics, symtabDiagnostics...)
+	diagnostics = append(diagnostics, analyze.TypeCheck(treeNode, fragmentMap)...)
+	if report(lines, diagnostics) {
+		return nil, 0, bucketMap, false
+	}
+
+	code, start, diagnostics := gen.Generate(treeNode, fragmentMap)
+	if report(lines, diagnostics) {
+		return nil, 0, bucketMap, false
+	}
+
+	return code, start, fragmentMap, true
+}
+
+/*
+Procedure Run reads statements from standard input and executes each complete fragment as soon as it is entered.
+Variables, procedures and machine memory persist from one fragment to the next, and errors are reported without leaving the loop.
+The limits apply to each fragment on its own.
+*/
+func Run(limits vm.Limits) {
+	session := vm.NewSession(bufio.NewReader(os.Stdin), limits)
+	gen := codegen.NewGenerator(locale.Locale)
+	bucketMap := make(map[string]types.Bucket)
+
+	for {
+		source, more := readFragment(session)
+		if !more {
+			fmt.Println()
+			return
+		}
+
+		code, start, fragmentMap, ok := compile(source, bucketMap, gen)
+		if !ok {
+			continue
+		}
+
+		bucketMap = fragmentMap
+		report(nil, session.Execute(code, start))
+	}
+}
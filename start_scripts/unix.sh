@@ -1,3 +1,3 @@
 #!/bin/bash
 
-../bin/mlpl $* ../localization/english.cfg
\ No newline at end of file
+../bin/mlpl $*
\ No newline at end of file
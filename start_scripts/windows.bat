@@ -1 +1 @@
-..\bin\mlpl.exe %* ..\localization\english.cfg
\ No newline at end of file
+..\bin\mlpl.exe %*
\ No newline at end of file
@@ -0,0 +1,382 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tm
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ivandejanovic/mlpl/types"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// cursor reads the operands of an instruction from left to right
+type cursor struct {
+	text string
+	pos  int
+}
+
+// Procedure skipSpace moves past blanks
+func (c *cursor) skipSpace() {
+	for c.pos < len(c.text) && (c.text[c.pos] == ' ' || c.text[c.pos] == '\t') {
+		c.pos++
+	}
+}
+
+// Function number reads an integer with an optional sign, false when there is none
+func (c *cursor) number() (int, bool) {
+	c.skipSpace()
+	start := c.pos
+	if c.pos < len(c.text) && (c.text[c.pos] == '-' || c.text[c.pos] == '+') {
+		c.pos++
+	}
+	for c.pos < len(c.text) && c.text[c.pos] >= '0' && c.text[c.pos] <= '9' {
+		c.pos++
+	}
+
+	value, err := strconv.Atoi(c.text[start:c.pos])
+	if err != nil {
+		c.pos = start
+		return 0, false
+	}
+
+	return value, true
+}
+
+// Function label reads a label name, empty when there is none
+func (c *cursor) label() string {
+	c.skipSpace()
+	start := c.pos
+	for c.pos < len(c.text) && isLabelRune(rune(c.text[c.pos]), c.pos == start) {
+		c.pos++
+	}
+
+	return c.text[start:c.pos]
+}
+
+// Function quoted reads a string in double quotes with the escapes of Go, false when there is none
+func (c *cursor) quoted() (string, bool) {
+	c.skipSpace()
+	literal, err := strconv.QuotedPrefix(c.text[c.pos:])
+	if err != nil || literal[0] != '"' {
+		return "", false
+	}
+	c.pos += len(literal)
+
+	value, err := strconv.Unquote(literal)
+
+	return value, err == nil
+}
+
+// Function expect moves past a separator, false when the next character is something else
+func (c *cursor) expect(separator byte) bool {
+	c.skipSpace()
+	if c.pos < len(c.text) && c.text[c.pos] == separator {
+		c.pos++
+		return true
+	}
+
+	return false
+}
+
+// Function end tells whether the operands are over, anything after a blank is a comment
+func (c *cursor) end() bool {
+	return c.pos == len(c.text) || c.text[c.pos] == ' ' || c.text[c.pos] == '\t'
+}
+
+// Function isLabelRune tells whether an ASCII letter, digit or underscore may appear in a label, labels do not start with a digit
+func isLabelRune(r rune, first bool) bool {
+	return r < unicode.MaxASCII && (r == '_' || unicode.IsLetter(r) || (!first && unicode.IsDigit(r)))
+}
+
+// Function isLabel tells whether a word is a label name
+func isLabel(word string) bool {
+	for index, r := range word {
+		if !isLabelRune(r, index == 0) {
+			return false
+		}
+	}
+
+	return word != ""
+}
+
+// Function asmError returns the diagnostic of a line the assembler cannot read
+func asmError(file string, line int, key string, args ...interface{}) types.Diagnostic {
+	return types.Diagnostic{File: file, Line: line, Severity: types.ErrorSeverity, Key: key, Args: args}
+}
+
+type labelUse struct {
+	index int    // Index of the instruction whose offset is the label
+	label string // Name of the label
+	line  int
+}
+
+/*
+Function Assemble reads a program written in the text assembly of the listing.
+
+Every line holds one instruction, in the form loc: OP operands. Lines starting with * are comments, and so is
+anything following the operands of an instruction. The location may be left out, the instruction then follows the
+one before it, but no two instructions may share a location. The string operand of PRINT and LDS is written in
+double quotes with the escapes of Go, as in "two\nlines", and registers are numbered from 0 to 7.
+
+A line may begin with labels such as loop: that name the location of the next instruction. A label can stand for the
+offset d of a d(s) operand. With the pc register 7 as s the assembler makes the offset relative, so the instruction
+reaches the label itself, with any other register the offset is the location of the label.
+*/
+func Assemble(file string, source io.Reader) ([]Instruction, []types.Diagnostic) {
+	var diagnostics []types.Diagnostic
+	var code []Instruction
+	var uses []labelUse
+	var pending []string
+	labels := make(map[string]int)
+	used := make(map[int]bool)
+	next := 0
+
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(nil, 1<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		rest := strings.TrimLeft(strings.TrimRight(scanner.Text(), "\r"), " \t")
+		if rest == "" || rest[0] == '*' {
+			continue
+		}
+
+		// Words ending in a colon in front of the opcode are the location and labels
+		loc := -1
+		for colon := strings.IndexByte(rest, ':'); colon >= 0; colon = strings.IndexByte(rest, ':') {
+			prefix := strings.TrimSpace(rest[:colon])
+			if isLabel(prefix) {
+				_, defined := labels[prefix]
+				for _, label := range pending {
+					defined = defined || label == prefix
+				}
+				if defined {
+					diagnostics = append(diagnostics, asmError(file, lineNo, "AsmDuplicateLabelError", prefix))
+				}
+				pending = append(pending, prefix)
+			} else if prefix != "" && prefix[0] >= '0' && prefix[0] <= '9' {
+				value, err := strconv.Atoi(prefix)
+				if err != nil {
+					diagnostics = append(diagnostics, asmError(file, lineNo, "AsmMemoryLocationError", prefix))
+				}
+				loc = value
+			} else {
+				break
+			}
+			rest = strings.TrimLeft(rest[colon+1:], " \t")
+		}
+
+		if rest == "" {
+			if loc >= 0 {
+				diagnostics = append(diagnostics, asmError(file, lineNo, "AsmMissingOpcodeError", loc))
+			}
+			continue
+		}
+		if loc < 0 {
+			loc = next
+		}
+		if used[loc] {
+			diagnostics = append(diagnostics, asmError(file, lineNo, "AsmDuplicateLocationError", loc))
+		}
+		used[loc] = true
+		next = loc + 1
+		for _, label := range pending {
+			labels[label] = loc
+		}
+		pending = nil
+
+		opName, operands := rest, ""
+		if blank := strings.IndexAny(rest, " \t"); blank >= 0 {
+			opName, operands = rest[:blank], rest[blank+1:]
+		}
+		op, ok := Lookup(opName)
+		if !ok {
+			diagnostics = append(diagnostics, asmError(file, lineNo, "AsmInvalidOpcodeError", loc))
+			continue
+		}
+
+		inst := Instruction{Loc: loc, Op: op}
+		c := &cursor{operands, 0}
+		key := ""
+		switch op.Class() {
+		case ClassSO:
+			if inst.Str, ok = c.quoted(); !ok {
+				key = "AsmInvalidFirstArgumentError"
+			} else if !c.end() {
+				key = "AsmInvalidNumberOfArgumentsError"
+			}
+		case ClassRS:
+			if inst.Arg1, ok = c.number(); !ok {
+				key = "AsmInvalidFirstArgumentError"
+			} else if !c.expect(',') {
+				key = "AsmInvalidNumberOfArgumentsError"
+			} else if op == LDS {
+				if inst.Str, ok = c.quoted(); !ok {
+					key = "AsmInvalidSecondArgumentError"
+				} else if !c.end() {
+					key = "AsmInvalidNumberOfArgumentsError"
+				}
+			} else if inst.Real, ok = parseReal(c.text[c.pos:]); !ok {
+				key = "AsmInvalidSecondArgumentError"
+			}
+		case ClassRM, ClassRA:
+			label := ""
+			if inst.Arg1, ok = c.number(); !ok {
+				key = "AsmInvalidFirstArgumentError"
+			} else if !c.expect(',') {
+				key = "AsmInvalidNumberOfArgumentsError"
+			} else if inst.Arg2, ok = c.number(); !ok {
+				if label = c.label(); label == "" {
+					key = "AsmInvalidSecondArgumentError"
+				}
+			}
+			if key == "" && !c.expect('(') {
+				key = "AsmInvalidNumberOfArgumentsError"
+			} else if key == "" {
+				if inst.Arg3, ok = c.number(); !ok {
+					key = "AsmInvalidThirdArgumentError"
+				} else if !c.expect(')') || !c.end() {
+					key = "AsmInvalidNumberOfArgumentsError"
+				}
+			}
+			if key == "" && label != "" {
+				uses = append(uses, labelUse{len(code), label, lineNo})
+			}
+		default:
+			if inst.Arg1, ok = c.number(); !ok {
+				key = "AsmInvalidFirstArgumentError"
+			} else if !c.expect(',') {
+				key = "AsmInvalidNumberOfArgumentsError"
+			} else if inst.Arg2, ok = c.number(); !ok {
+				key = "AsmInvalidSecondArgumentError"
+			} else if !c.expect(',') {
+				key = "AsmInvalidNumberOfArgumentsError"
+			} else if inst.Arg3, ok = c.number(); !ok {
+				key = "AsmInvalidThirdArgumentError"
+			} else if !c.end() {
+				key = "AsmInvalidNumberOfArgumentsError"
+			}
+		}
+
+		if key != "" {
+			diagnostics = append(diagnostics, asmError(file, lineNo, key, loc))
+			continue
+		}
+		for _, reg := range inst.Registers() {
+			if reg < 0 || reg >= NumRegs {
+				diagnostics = append(diagnostics, asmError(file, lineNo, "AsmInvalidRegisterError", reg, loc))
+			}
+		}
+		code = append(code, inst)
+	}
+
+	// Labels at the end name the location after the last instruction
+	for _, label := range pending {
+		labels[label] = next
+	}
+
+	for _, use := range uses {
+		target, ok := labels[use.label]
+		if !ok {
+			diagnostics = append(diagnostics, asmError(file, use.line, "AsmUnknownLabelError", use.label))
+			continue
+		}
+
+		inst := &code[use.index]
+		inst.Arg2 = target
+		if inst.Arg3 == PC {
+			inst.Arg2 = target - (inst.Loc + 1)
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+
+	return code, nil
+}
+
+// Function parseReal reads the real operand of LDCF, anything after a blank is a comment
+func parseReal(text string) (float64, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return 0, false
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+
+	return value, err == nil
+}
+
+// Function target returns the location a relative jump or address reaches, false for instructions that do not reach one
+func target(inst Instruction) (int, bool) {
+	if inst.Op.Class() != ClassRA || inst.Op == LDC || inst.Op == CHK || inst.Arg3 != PC {
+		return 0, false
+	}
+
+	return inst.Loc + 1 + inst.Arg2, true
+}
+
+/*
+Function Disassemble returns a listing of a program in location order that Assemble reads back into the same program.
+The comments of a location are written above its instruction, and locations that jumps reach get labels.
+*/
+func Disassemble(code []Instruction, comments map[int][]string) []string {
+	sorted := append([]Instruction(nil), code...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Loc < sorted[j].Loc
+	})
+
+	present := make(map[int]bool)
+	for _, inst := range sorted {
+		present[inst.Loc] = true
+	}
+	targets := make(map[int]bool)
+	for _, inst := range sorted {
+		if loc, ok := target(inst); ok && present[loc] {
+			targets[loc] = true
+		}
+	}
+
+	lines := make([]string, 0, len(sorted))
+	for _, inst := range sorted {
+		for _, comment := range comments[inst.Loc] {
+			lines = append(lines, "* "+comment)
+		}
+		if targets[inst.Loc] {
+			lines = append(lines, fmt.Sprintf("L%d:", inst.Loc))
+		}
+
+		if loc, ok := target(inst); ok && targets[loc] {
+			lines = append(lines, fmt.Sprintf("%3d: %5s %d, L%d(%d)", inst.Loc, inst.Op, inst.Arg1, loc, inst.Arg3))
+		} else {
+			lines = append(lines, inst.String())
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,78 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ivandejanovic/mlpl/locale"
+)
+
+func TestAssembleDisassemble(t *testing.T) {
+	listing := Disassemble(program, map[int][]string{1: {"line 1"}})
+
+	code, diagnostics := Assemble("test.tm", strings.NewReader(strings.Join(listing, "\n")))
+	if diagnostics != nil {
+		t.Fatalf("Assemble reported %v for\n%s", diagnostics, strings.Join(listing, "\n"))
+	}
+	if !reflect.DeepEqual(code, program) {
+		t.Errorf("Assemble returned\n%v\nwant\n%v", code, program)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		key    string
+		line   int
+	}{
+		{"LD 9, 0(0)", "AsmInvalidRegisterError", 1},
+		{"HALT 0, 0, 0\nADD 0, 8, 1", "AsmInvalidRegisterError", 2},
+		{"LDS -1, \"x\"", "AsmInvalidRegisterError", 1},
+		{"0: HALT 0, 0, 0\n\n0: OUT 0, 0, 0", "AsmDuplicateLocationError", 3},
+		{"PRINT hello", "AsmInvalidFirstArgumentError", 1},
+		{"LDS 0, \"open", "AsmInvalidSecondArgumentError", 1},
+		{"JEQ 0, nowhere(7)", "AsmUnknownLabelError", 1},
+		{"a: HALT 0, 0, 0\na: HALT 0, 0, 0", "AsmDuplicateLabelError", 2},
+		{"* comment\nNOP 0, 0, 0", "AsmInvalidOpcodeError", 2},
+	}
+
+	for _, test := range tests {
+		code, diagnostics := Assemble("test.tm", strings.NewReader(test.source))
+		if code != nil || len(diagnostics) != 1 {
+			t.Errorf("%q: Assemble returned %v and %v, want one diagnostic", test.source, code, diagnostics)
+			continue
+		}
+		if diagnostics[0].Key != test.key || diagnostics[0].Line != test.line {
+			t.Errorf("%q: got %s on line %d, want %s on line %d", test.source, diagnostics[0].Key, diagnostics[0].Line, test.key, test.line)
+		}
+		// The line is part of the position, the message itself takes only the arguments
+		if message := locale.Message(diagnostics[0]); strings.Contains(message, "%!") || strings.Contains(message, "line") {
+			t.Errorf("%q: message %q repeats the line or does not match its arguments", test.source, message)
+		}
+	}
+}
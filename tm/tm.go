@@ -0,0 +1,383 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package tm holds the instruction set of the Tiny Machine shared by the code generator and the virtual machine
+package tm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PC is the register that holds the location of the next instruction
+const PC = 7
+
+// NumRegs is the number of registers, numbered from zero
+const NumRegs = 8
+
+// DataSize is the number of cells of data memory
+const DataSize = 4096
+
+// StackReserve is the number of cells kept free below the stack for temporaries
+const StackReserve = 64
+
+type Opcode uint8
+
+/*
+Opcode values are written to bytecode, so every opcode keeps its value for good.
+A new opcode takes the next unused value wherever it is listed, and changing a value needs a new bytecode.Version.
+*/
+const (
+	// RO instructions
+	HALT  Opcode = 1  // RO     halt, operands are ignored
+	PRINT Opcode = 2  // SO     print, print the string operand to console
+	IN    Opcode = 3  // RO     read into reg(r); s and t are ignored
+	OUT   Opcode = 4  // RO     write from reg(r), s and t are ignored
+	ADD   Opcode = 5  // RO     reg(r) = reg(s)+reg(t)
+	SUB   Opcode = 6  // RO     reg(r) = reg(s)-reg(t)
+	MUL   Opcode = 7  // RO     reg(r) = reg(s)*reg(t)
+	DIV   Opcode = 8  // RO     reg(r) = reg(s)/reg(t)
+	CAT   Opcode = 9  // RO     reg(r) = string reg(s) joined with string reg(t)
+	STR   Opcode = 10 // RO     reg(r) = string with decimal text of reg(s), t is ignored
+	INS   Opcode = 11 // RO     read line of text into string reg(r); s and t are ignored
+	OUTS  Opcode = 12 // RO     write string reg(r), s and t are ignored
+	ADDF  Opcode = 13 // RO     real reg(r) = reg(s)+reg(t)
+	SUBF  Opcode = 14 // RO     real reg(r) = reg(s)-reg(t)
+	MULF  Opcode = 15 // RO     real reg(r) = reg(s)*reg(t)
+	DIVF  Opcode = 16 // RO     real reg(r) = reg(s)/reg(t)
+	FLT   Opcode = 17 // RO     real reg(r) = integer reg(s), t is ignored
+	CMPF  Opcode = 18 // RO     reg(r) = -1, 0 or 1 as real reg(s) is less, equal or greater than real reg(t)
+	INF   Opcode = 19 // RO     read real into reg(r); s and t are ignored
+	OUTF  Opcode = 20 // RO     write real reg(r), s and t are ignored
+	STRF  Opcode = 21 // RO     reg(r) = string with decimal text of real reg(s), t is ignored
+
+	// RM instructions
+	LD Opcode = 22 // RM     reg(r) = mem(d+reg(s))
+	ST Opcode = 23 // RM     mem(d+reg(s)) = reg(r)
+
+	// RA instructions
+	LDA Opcode = 24 // RA     reg(r) = d+reg(s)
+	LDC Opcode = 25 // RA     reg(r) = d ; reg(s) is ignored
+	JLT Opcode = 26 // RA     if reg(r)<0 then reg(7) = d+reg(s)
+	JLE Opcode = 27 // RA     if reg(r)<=0 then reg(7) = d+reg(s)
+	JGT Opcode = 28 // RA     if reg(r)>0 then reg(7) = d+reg(s)
+	JGE Opcode = 29 // RA     if reg(r)>=0 then reg(7) = d+reg(s)
+	JEQ Opcode = 30 // RA     if reg(r)==0 then reg(7) = d+reg(s)
+	JNE Opcode = 31 // RA     if reg(r)!=0 then reg(7) = d+reg(s)
+	CHK Opcode = 32 // RA     if reg(r)<0 or reg(r)>=d then index error ; reg(s) is ignored
+	STK Opcode = 33 // RA     if reg(r)<d+reg(s) then stack overflow error
+
+	// RS instructions
+	LDS  Opcode = 34 // RS     reg(r) = string with the text of the operand
+	LDCF Opcode = 35 // RS     real reg(r) = the real operand
+)
+
+type Class int
+
+// Operand layouts of the instructions
+const (
+	ClassRO Class = 1 + iota // Registers r, s, t
+	ClassRM                  // Register r, memory d+reg(s)
+	ClassRA                  // Register r, address d+reg(s)
+	ClassSO                  // String operand only
+	ClassRS                  // Register r and a string or real operand
+)
+
+var names = [...]string{
+	HALT: "HALT", PRINT: "PRINT", IN: "IN", OUT: "OUT", ADD: "ADD", SUB: "SUB", MUL: "MUL", DIV: "DIV",
+	CAT: "CAT", STR: "STR", INS: "INS", OUTS: "OUTS", ADDF: "ADDF", SUBF: "SUBF", MULF: "MULF", DIVF: "DIVF",
+	FLT: "FLT", CMPF: "CMPF", INF: "INF", OUTF: "OUTF", STRF: "STRF",
+	LD: "LD", ST: "ST",
+	LDA: "LDA", LDC: "LDC", JLT: "JLT", JLE: "JLE", JGT: "JGT", JGE: "JGE", JEQ: "JEQ", JNE: "JNE", CHK: "CHK", STK: "STK",
+	LDS: "LDS", LDCF: "LDCF",
+}
+
+/*
+Instruction is one Tiny Machine instruction placed at a location of instruction memory.
+Arg1, Arg2 and Arg3 are r, s and t of RO instructions and r, d and s of RM and RA instructions, RS instructions use Arg1 for r.
+*/
+type Instruction struct {
+	Loc  int
+	Op   Opcode
+	Arg1 int
+	Arg2 int
+	Arg3 int
+	Str  string  // Operand of PRINT and LDS
+	Real float64 // Operand of LDCF
+}
+
+// Function Valid tells whether op is an opcode of the machine
+func (op Opcode) Valid() bool {
+	return int(op) < len(names) && names[op] != ""
+}
+
+// Function String returns the mnemonic of an opcode
+func (op Opcode) String() string {
+	if !op.Valid() {
+		return "Opcode(" + strconv.Itoa(int(op)) + ")"
+	}
+
+	return names[op]
+}
+
+// Function Class returns the operand layout of an opcode
+func (op Opcode) Class() Class {
+	switch op {
+	case PRINT:
+		return ClassSO
+	case LDS, LDCF:
+		return ClassRS
+	case LD, ST:
+		return ClassRM
+	case LDA, LDC, JLT, JLE, JGT, JGE, JEQ, JNE, CHK, STK:
+		return ClassRA
+	}
+
+	return ClassRO
+}
+
+// Function Lookup returns the opcode with a mnemonic, false when there is none
+func Lookup(name string) (Opcode, bool) {
+	for op, mnemonic := range names {
+		if mnemonic == name && mnemonic != "" {
+			return Opcode(op), true
+		}
+	}
+
+	return 0, false
+}
+
+// Function String returns the instruction in the text assembly of the listing
+func (inst Instruction) String() string {
+	switch inst.Op.Class() {
+	case ClassSO:
+		return fmt.Sprintf("%3d: %5s %s", inst.Loc, inst.Op, strconv.Quote(inst.Str))
+	case ClassRS:
+		if inst.Op == LDCF {
+			return fmt.Sprintf("%3d: %5s %d, %s", inst.Loc, inst.Op, inst.Arg1, strconv.FormatFloat(inst.Real, 'g', -1, 64))
+		}
+		return fmt.Sprintf("%3d: %5s %d, %s", inst.Loc, inst.Op, inst.Arg1, strconv.Quote(inst.Str))
+	case ClassRM, ClassRA:
+		return fmt.Sprintf("%3d: %5s %d, %d(%d)", inst.Loc, inst.Op, inst.Arg1, inst.Arg2, inst.Arg3)
+	}
+
+	return fmt.Sprintf("%3d: %5s %d, %d, %d", inst.Loc, inst.Op, inst.Arg1, inst.Arg2, inst.Arg3)
+}
+
+// Function Registers returns the operands of the instruction that name registers
+func (inst Instruction) Registers() []int {
+	switch inst.Op.Class() {
+	case ClassSO:
+		return nil
+	case ClassRS:
+		return []int{inst.Arg1}
+	case ClassRM, ClassRA:
+		return []int{inst.Arg1, inst.Arg3}
+	}
+
+	return []int{inst.Arg1, inst.Arg2, inst.Arg3}
+}
+
+// Function Listing returns the text assembly of a program, one instruction per line
+func Listing(code []Instruction) []string {
+	lines := make([]string, 0, len(code))
+	for _, inst := range code {
+		lines = append(lines, inst.String())
+	}
+
+	return lines
+}
+
+var errFormat = errors.New("tm: malformed bytecode")
+
+// Procedure putUvarint appends an unsigned varint to buf
+func putUvarint(buf *bytes.Buffer, value uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], value)])
+}
+
+// Procedure putVarint appends a signed varint to buf
+func putVarint(buf *bytes.Buffer, value int) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutVarint(scratch[:], int64(value))])
+}
+
+/*
+Procedure Encode writes a program in the compact binary form. All numbers are varints.
+
+	string count, then every string as its byte length and bytes
+	instruction count, then every instruction as location and opcode followed by
+	  r, s, t or r, d, s for RO, RM and RA instructions
+	  string index for PRINT, r and string index for LDS
+	  r and the eight little endian bytes of the real for LDCF
+
+Strings are stored once however many instructions use them.
+*/
+func Encode(w io.Writer, code []Instruction) error {
+	var buf bytes.Buffer
+	strs := make([]string, 0)
+	index := make(map[string]int)
+	for _, inst := range code {
+		if inst.Op == PRINT || inst.Op == LDS {
+			if _, ok := index[inst.Str]; !ok {
+				index[inst.Str] = len(strs)
+				strs = append(strs, inst.Str)
+			}
+		}
+	}
+
+	putUvarint(&buf, uint64(len(strs)))
+	for _, s := range strs {
+		putUvarint(&buf, uint64(len(s)))
+		buf.WriteString(s)
+	}
+
+	putUvarint(&buf, uint64(len(code)))
+	for _, inst := range code {
+		putUvarint(&buf, uint64(inst.Loc))
+		buf.WriteByte(byte(inst.Op))
+		switch {
+		case inst.Op == PRINT:
+			putUvarint(&buf, uint64(index[inst.Str]))
+		case inst.Op == LDS:
+			putVarint(&buf, inst.Arg1)
+			putUvarint(&buf, uint64(index[inst.Str]))
+		case inst.Op == LDCF:
+			putVarint(&buf, inst.Arg1)
+			var bits [8]byte
+			binary.LittleEndian.PutUint64(bits[:], math.Float64bits(inst.Real))
+			buf.Write(bits[:])
+		default:
+			putVarint(&buf, inst.Arg1)
+			putVarint(&buf, inst.Arg2)
+			putVarint(&buf, inst.Arg3)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// Function readInt reads a signed varint that fits an int
+func readInt(r *bufio.Reader) (int, error) {
+	value, err := binary.ReadVarint(r)
+	if err != nil || int64(int(value)) != value {
+		return 0, errFormat
+	}
+
+	return int(value), nil
+}
+
+// Function readCount reads an unsigned varint that is at most limit
+func readCount(r *bufio.Reader, limit uint64) (int, error) {
+	value, err := binary.ReadUvarint(r)
+	if err != nil || value > limit {
+		return 0, errFormat
+	}
+
+	return int(value), nil
+}
+
+// Function Decode reads a program written by Encode, it returns an error for data Encode could not have written
+func Decode(reader io.Reader) ([]Instruction, error) {
+	r, ok := reader.(*bufio.Reader)
+	if !ok {
+		r = bufio.NewReader(reader)
+	}
+
+	count, err := readCount(r, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0)
+	for index := 0; index < count; index++ {
+		length, err := readCount(r, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		// The string grows as its bytes arrive, so a length past the end of the data allocates no more than the data
+		var s strings.Builder
+		if _, err := io.CopyN(&s, r, int64(length)); err != nil {
+			return nil, errFormat
+		}
+		strs = append(strs, s.String())
+	}
+
+	if count, err = readCount(r, math.MaxInt32); err != nil {
+		return nil, err
+	}
+	code := make([]Instruction, 0)
+	for index := 0; index < count; index++ {
+		var inst Instruction
+		if inst.Loc, err = readCount(r, math.MaxInt32); err != nil {
+			return nil, err
+		}
+		op, err := r.ReadByte()
+		if err != nil || !Opcode(op).Valid() {
+			return nil, errFormat
+		}
+		inst.Op = Opcode(op)
+
+		switch inst.Op {
+		case PRINT, LDS:
+			if inst.Op == LDS {
+				if inst.Arg1, err = readInt(r); err != nil {
+					return nil, err
+				}
+			}
+			s, err := readCount(r, uint64(len(strs)))
+			if err != nil || s == len(strs) {
+				return nil, errFormat
+			}
+			inst.Str = strs[s]
+		case LDCF:
+			if inst.Arg1, err = readInt(r); err != nil {
+				return nil, err
+			}
+			var bits [8]byte
+			if _, err := io.ReadFull(r, bits[:]); err != nil {
+				return nil, errFormat
+			}
+			inst.Real = math.Float64frombits(binary.LittleEndian.Uint64(bits[:]))
+		default:
+			for _, arg := range []*int{&inst.Arg1, &inst.Arg2, &inst.Arg3} {
+				if *arg, err = readInt(r); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		code = append(code, inst)
+	}
+
+	return code, nil
+}
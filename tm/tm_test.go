@@ -0,0 +1,105 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// program holds an instruction of every operand layout, with strings the text assembly has to escape
+var program = []Instruction{
+	{Loc: 0, Op: LD, Arg1: 6, Arg2: 0, Arg3: 0},
+	{Loc: 1, Op: PRINT, Str: "two\nlines"},
+	{Loc: 2, Op: LDS, Arg1: 0, Str: "a \"quoted\", tab\there: (x)"},
+	{Loc: 3, Op: OUTS, Arg1: 0},
+	{Loc: 4, Op: LDCF, Arg1: 1, Real: -2.5e-3},
+	{Loc: 5, Op: OUTF, Arg1: 1},
+	{Loc: 6, Op: LDC, Arg1: 0, Arg2: -3, Arg3: 0},
+	{Loc: 7, Op: ADD, Arg1: 0, Arg2: 0, Arg3: 1},
+	{Loc: 8, Op: JLT, Arg1: 0, Arg2: -3, Arg3: PC},
+	{Loc: 9, Op: PRINT, Str: ""},
+	{Loc: 10, Op: HALT},
+}
+
+func TestEncodeDecode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, program); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	code, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !reflect.DeepEqual(code, program) {
+		t.Errorf("Decode returned\n%v\nwant\n%v", code, program)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	Encode(&buf, program)
+	data := buf.Bytes()
+
+	tests := map[string][]byte{
+		"empty":       nil,
+		"truncated":   data[:len(data)-1],
+		"long string": {1, 0xff, 0xff, 0xff, 0xff, 0x07, 'a'},
+		"bad opcode":  {0, 1, 0, 0xff},
+		"bad string":  {0, 1, 0, byte(PRINT), 0},
+	}
+	for name, data := range tests {
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode accepted malformed data", name)
+		}
+	}
+}
+
+// TestOpcodeValues pins the values written to bytecode, which must not change without a new bytecode version
+func TestOpcodeValues(t *testing.T) {
+	values := map[string]Opcode{
+		"HALT": 1, "PRINT": 2, "IN": 3, "OUT": 4, "ADD": 5, "SUB": 6, "MUL": 7, "DIV": 8,
+		"CAT": 9, "STR": 10, "INS": 11, "OUTS": 12, "ADDF": 13, "SUBF": 14, "MULF": 15, "DIVF": 16,
+		"FLT": 17, "CMPF": 18, "INF": 19, "OUTF": 20, "STRF": 21, "LD": 22, "ST": 23,
+		"LDA": 24, "LDC": 25, "JLT": 26, "JLE": 27, "JGT": 28, "JGE": 29, "JEQ": 30, "JNE": 31,
+		"CHK": 32, "STK": 33, "LDS": 34, "LDCF": 35,
+	}
+	for name, want := range values {
+		op, ok := Lookup(name)
+		if !ok || op != want {
+			t.Errorf("Lookup(%q) = %d, %v, want %d", name, op, ok, want)
+		}
+		if op.String() != name || !op.Valid() || op.Class() == 0 {
+			t.Errorf("opcode %d: name %q, valid %v, class %d", op, op.String(), op.Valid(), op.Class())
+		}
+	}
+	for _, op := range []Opcode{0, 36} {
+		if op.Valid() {
+			t.Errorf("opcode %d is valid", op)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package translate
+
+import (
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/parse"
+	"github.com/ivandejanovic/mlpl/types"
+	"io"
+	"strings"
+)
+
+/*
+Function Translate rewrites source written with the keywords of one locale to the keywords of another.
+Identifiers, strings, comments and white space are kept exactly, except that a locale pragma names the target locale and real numbers get its decimal separator.
+A comma right before a number is followed by a blank when the target locale writes its decimal separator the same way, so arguments do not run together into a real number.
+Identifiers that are keywords of the target locale are reported as warnings, the translated program would not parse.
+*/
+func Translate(source io.Reader, from *locale.LocaleType, to *locale.LocaleType) (string, []types.Diagnostic) {
+	var translation strings.Builder
+
+	tokens, diagnostics := parse.ParseTrivia(source, from)
+
+	for index, token := range tokens {
+		switch {
+		case from.Keyword(token.TokenType) != "":
+			translation.WriteString(to.Keyword(token.TokenType))
+		case token.TokenType == types.COMMENT && token.Lineno == 1 && locale.PragmaName(token.TokenString) != "":
+			translation.WriteString(locale.Pragma(to.Name))
+		case token.TokenType == types.REAL:
+			translation.WriteString(strings.Replace(token.TokenString, from.DecimalSeparator, to.DecimalSeparator, 1))
+		case token.TokenType == types.ID && to.Lookup(token.TokenString) != types.ID:
+			diagnostic := types.Diagnostic{Line: token.Lineno, Column: token.Column, Severity: types.WarningSeverity, Key: "TranslateKeywordWarning", Args: []interface{}{token.TokenString}}
+			diagnostics = append(diagnostics, diagnostic)
+			translation.WriteString(token.TokenString)
+		case token.TokenType == types.COMMA && token.TokenString == to.DecimalSeparator && index+1 < len(tokens) && isNumber(tokens[index+1]):
+			translation.WriteString(token.TokenString + " ")
+		default:
+			translation.WriteString(token.TokenString)
+		}
+	}
+
+	return translation.String(), diagnostics
+}
+
+// Function isNumber tells whether a token is an integer or real literal
+func isNumber(token types.Token) bool {
+	return token.TokenType == types.NUM || token.TokenType == types.REAL
+}
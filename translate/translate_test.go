@@ -0,0 +1,82 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package translate
+
+import (
+	"github.com/ivandejanovic/mlpl/locale"
+	"strings"
+	"testing"
+)
+
+// Function load returns a bundled locale
+func load(t *testing.T, name string) *locale.LocaleType {
+	config, ok := locale.BundledConfig(name)
+	if !ok {
+		t.Fatalf("%s locale is not bundled", name)
+	}
+	loc := locale.New()
+	if diagnostics := loc.Load(name+".cfg", config); len(diagnostics) > 0 {
+		t.Fatalf("Load reported %v", diagnostics)
+	}
+
+	return loc
+}
+
+func TestTranslateNumericArguments(t *testing.T) {
+	english, serbian := load(t, "english"), load(t, "serbian")
+
+	tests := []struct {
+		from, to *locale.LocaleType
+		source   string
+		want     string
+	}{
+		{english, serbian, "hello(2,3);\nwrite 1.5;\n", "hello(2, 3);\nispisi 1,5;\n"},
+		{english, serbian, "hello(1.5,2.25,x,4);\n", "hello(1,5, 2,25,x, 4);\n"},
+		{english, serbian, "hello(2, 3);\n", "hello(2, 3);\n"},
+		{serbian, english, "hello(2, 3);\nispisi 1,5;\n", "hello(2, 3);\nwrite 1.5;\n"},
+		{serbian, english, "hello(1,5, 2);\n", "hello(1.5, 2);\n"},
+	}
+
+	for _, test := range tests {
+		translation, diagnostics := Translate(strings.NewReader(test.source), test.from, test.to)
+		if len(diagnostics) > 0 {
+			t.Errorf("%q: Translate reported %v", test.source, diagnostics)
+		}
+		if translation != test.want {
+			t.Errorf("%q: Translate returned %q, want %q", test.source, translation, test.want)
+		}
+	}
+}
+
+func TestTranslateRoundTrip(t *testing.T) {
+	english, serbian := load(t, "english"), load(t, "serbian")
+	source := "#! locale: english #\nprocedure hello(a, b)\n  write a + b;\nend\nhello(2, 3);\n"
+
+	there, _ := Translate(strings.NewReader(source), english, serbian)
+	back, _ := Translate(strings.NewReader(there), serbian, english)
+	if back != source {
+		t.Errorf("translating to Serbian and back returned %q, want %q", back, source)
+	}
+}
@@ -38,32 +38,50 @@ const (
 	UNTIL
 	READ
 	WRITE
+	WHILE
+	DO
+	PROCEDURE
+	RETURN
+	AND
+	OR
+	NOT
+	ARRAY
+	LENGTH
+	TRUE
+	FALSE
 	// Multicharacter tokens.
 	ID
 	NUM
+	REAL
 	STRING
 	// Special symbols.
 	ASSIGN
 	EQ
 	LT
+	GT
+	LE
+	GE
+	NE
 	PLUS
 	MINUS
 	TIMES
 	OVER
 	LPAREN
 	RPAREN
+	LBRACKET
+	RBRACKET
 	SEMI
+	COMMA
+	// Trivia, only returned by the scanner in trivia mode
+	SPACE
+	COMMENT
 )
 
-type ReservedWord struct {
-	TokenType TokenType
-	Str       string
-}
-
 type Token struct {
 	TokenType   TokenType
 	TokenString string
 	Lineno      int
+	Column      int // Position of the first rune of the token on its line, starting from one
 }
 
 type NodeKind int
@@ -81,6 +99,11 @@ const (
 	AssignK
 	ReadK
 	WriteK
+	WhileK
+	ProcK
+	CallK
+	ReturnK
+	ArrayK
 )
 
 type ExpKind int
@@ -90,6 +113,11 @@ const (
 	ConstK
 	IdK
 	StringK
+	CallExpK
+	IndexK
+	LengthK
+	BoolK
+	RealK
 )
 
 type ExpType int
@@ -99,17 +127,20 @@ const (
 	Integer
 	Boolean
 	String
+	Real
 )
 
 type TreeNode struct {
 	Children  []*TreeNode
 	Sibling   *TreeNode
 	Lineno    int
+	Column    int
 	Node      NodeKind
 	Stmt      StmtKind
 	Exp       ExpKind
 	Op        TokenType
 	Val       int
+	ValReal   float64
 	Name      string
 	ValString string
 	Type      ExpType
@@ -120,8 +151,38 @@ type LineList struct {
 	Next   *LineList
 }
 
+type SymbolKind int
+
+const (
+	VarSym SymbolKind = 1 + iota
+	ProcSym
+	ArraySym
+)
+
 type Bucket struct {
 	Name   string
 	Lines  *LineList
 	MemLoc int
+	Kind   SymbolKind
+	Size   int               // Number of memory cells, array length for arrays and frame size for procedures
+	Type   ExpType           // Type of value held by a variable or array elements, Void until first assigned
+	Params []string          // Parameter names of a procedure, in declaration order
+	Scope  map[string]Bucket // Local variables of a procedure, parameters included
+}
+
+type Severity int
+
+const (
+	ErrorSeverity Severity = 1 + iota
+	WarningSeverity
+)
+
+// Diagnostic is a problem found while compiling or running a program. Key names the localized message and Args fill in its verbs.
+type Diagnostic struct {
+	File     string
+	Line     int // Zero when the problem has no source position
+	Column   int // Zero when only the line is known
+	Severity Severity
+	Key      string
+	Args     []interface{}
 }
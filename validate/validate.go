@@ -0,0 +1,269 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/parse"
+	"github.com/ivandejanovic/mlpl/types"
+	"golang.org/x/text/unicode/norm"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Printf verbs of a message, %% is not a verb as it takes no argument
+var verbPattern = regexp.MustCompile(`%[-+# 0]*(\*|[0-9]+)?(\.(\*|[0-9]+)?)?[a-zA-Z%]`)
+
+type validator struct {
+	configFile  string
+	config      []byte
+	diagnostics []types.Diagnostic
+}
+
+// A word of the configuration with the English word it stands for and the key it is found under
+type word struct {
+	text string
+	role string
+	key  string
+	nth  int // Occurrences of the same text under the key before this one
+}
+
+// Procedure report records a problem found under a key of the configuration, at the nth occurrence of the text after the key when there is one
+func (v *validator) report(severity types.Severity, key string, text string, nth int, messageKey string, args ...interface{}) {
+	diagnostic := types.Diagnostic{File: v.configFile, Severity: severity, Key: messageKey, Args: args}
+
+	if key == "" {
+		v.diagnostics = append(v.diagnostics, diagnostic)
+		return
+	}
+
+	if offset, from := locale.KeyOffset(v.config, key); offset >= 0 {
+		for ; text != "" && nth >= 0; nth-- {
+			found := bytes.Index(v.config[from:], []byte(`"`+text+`"`))
+			if found < 0 {
+				break
+			}
+			offset = from + found
+			from = offset + 1
+		}
+		diagnostic.Line, diagnostic.Column = locale.ConfigPosition(v.config, offset)
+	}
+
+	v.diagnostics = append(v.diagnostics, diagnostic)
+}
+
+// Function keyName returns the configuration key of a locale field
+func keyName(field string) string {
+	return strings.ToLower(field[:1]) + field[1:]
+}
+
+// An ancestor of a configuration with the keys it has
+type ancestor struct {
+	name   string
+	fields map[string]json.RawMessage
+}
+
+// Function hasKey reports whether a configuration has a key for a locale field, matching them like json does
+func hasKey(fields map[string]json.RawMessage, field string) bool {
+	for key := range fields {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Function ancestors returns the locales a configuration extends, nearest first
+func ancestors(config []byte) []ancestor {
+	var found []ancestor
+
+	seen := make(map[string]bool)
+	for name := locale.Resolve(locale.ExtendsOf(config)); name != "" && !seen[name]; {
+		seen[name] = true
+
+		_, parent, ok := locale.Find(name)
+		if !ok {
+			break
+		}
+
+		var fields map[string]json.RawMessage
+		json.Unmarshal(parent, &fields)
+		found = append(found, ancestor{name, fields})
+
+		name = locale.Resolve(locale.ExtendsOf(parent))
+	}
+
+	return found
+}
+
+/*
+Procedure checkKeys reports configuration keys that are not locale fields and locale fields that have no key.
+Keys left out of a configuration that extends another locale are only warnings telling which locale they are taken from.
+*/
+func (v *validator) checkKeys(fields map[string]json.RawMessage) {
+	localeType := reflect.TypeOf(locale.LocaleType{})
+
+	for key := range fields {
+		if field, ok := localeType.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) }); !ok || field.Tag.Get("json") == "-" {
+			v.report(types.ErrorSeverity, key, "", 0, "LocaleUnknownKeyError", key)
+		}
+	}
+
+	parents := ancestors(v.config)
+
+	for index := 0; index < localeType.NumField(); index++ {
+		field := localeType.Field(index)
+		if field.Tag.Get("json") == "-" || field.Name == "Extends" || hasKey(fields, field.Name) {
+			continue
+		}
+
+		if len(parents) == 0 {
+			v.report(types.ErrorSeverity, "", "", 0, "LocaleMissingKeyError", keyName(field.Name))
+			continue
+		}
+
+		// Keys no ancestor has keep the English message every locale starts from
+		source := "english"
+		for _, parent := range parents {
+			if hasKey(parent.fields, field.Name) {
+				source = parent.name
+				break
+			}
+		}
+		v.report(types.WarningSeverity, "", "", 0, "LocaleFallbackWarning", keyName(field.Name), source)
+	}
+}
+
+/*
+Procedure checkWords reports words that are used twice, also when only composed differently, and words for different things that differ only in letter case.
+Keywords must also be identifiers, anything else the scanner splits into several tokens.
+*/
+func (v *validator) checkWords(words []word, identifiers bool) {
+	for index, current := range words {
+		if identifiers && !parse.IsIdentifier(current.text) {
+			v.report(types.ErrorSeverity, current.key, current.text, current.nth, "LocaleIdentifierError", current.text, current.role)
+		}
+
+		for _, previous := range words[:index] {
+			if norm.NFC.String(current.text) == norm.NFC.String(previous.text) {
+				v.report(types.ErrorSeverity, current.key, current.text, current.nth, "LocaleDuplicateKeywordError", current.text, previous.role, current.role)
+			} else if strings.EqualFold(current.text, previous.text) && current.role != previous.role {
+				v.report(types.WarningSeverity, current.key, current.text, current.nth, "LocaleCaseCollisionWarning", previous.text, previous.role, current.text, current.role)
+			}
+		}
+	}
+}
+
+// Procedure checkKeywords checks the keywords and the debugger commands of a locale, each against the others of its kind
+func (v *validator) checkKeywords(loc *locale.LocaleType, english *locale.LocaleType) {
+	keywords := make([]word, 0, locale.ReservedLength)
+	for index, aliases := range loc.ReservedArray {
+		for _, keyword := range aliases {
+			nth := 0
+			for _, previous := range keywords {
+				if previous.text == keyword {
+					nth++
+				}
+			}
+			keywords = append(keywords, word{keyword, english.ReservedArray[index][0], "reservedArray", nth})
+		}
+	}
+	v.checkWords(keywords, true)
+
+	commands := []word{
+		{loc.DebugStepCommand, english.DebugStepCommand, "debugStepCommand", 0},
+		{loc.DebugBreakCommand, english.DebugBreakCommand, "debugBreakCommand", 0},
+		{loc.DebugContinueCommand, english.DebugContinueCommand, "debugContinueCommand", 0},
+		{loc.DebugPrintCommand, english.DebugPrintCommand, "debugPrintCommand", 0},
+		{loc.DebugQuitCommand, english.DebugQuitCommand, "debugQuitCommand", 0},
+	}
+	v.checkWords(commands, false)
+}
+
+// Function verbs returns the printf verbs of a message in order
+func verbs(message string) []string {
+	found := make([]string, 0)
+	for _, verb := range verbPattern.FindAllString(message, -1) {
+		if verb != "%%" {
+			found = append(found, verb)
+		}
+	}
+
+	return found
+}
+
+// Procedure checkVerbs reports messages whose printf verbs differ from the verbs of the English message
+func (v *validator) checkVerbs(loc *locale.LocaleType, english *locale.LocaleType) {
+	localized := reflect.ValueOf(loc).Elem()
+	template := reflect.ValueOf(english).Elem()
+
+	for index := 0; index < localized.NumField(); index++ {
+		if localized.Field(index).Kind() != reflect.String || localized.Type().Field(index).Tag.Get("json") == "-" {
+			continue
+		}
+
+		expected := verbs(template.Field(index).String())
+		actual := verbs(localized.Field(index).String())
+		if strings.Join(expected, " ") != strings.Join(actual, " ") {
+			key := keyName(localized.Type().Field(index).Name)
+			v.report(types.ErrorSeverity, key, "", 0, "LocaleVerbError", key, actual, expected)
+		}
+	}
+}
+
+/*
+Function Config checks a locale configuration against the English locale.
+It reports unknown and missing keys, keywords that are not identifiers, keywords used twice or differing only in letter case
+and messages whose printf verbs do not match the English message. Keys a configuration takes from the locale it extends are reported as warnings.
+*/
+func Config(configFile string, config []byte) []types.Diagnostic {
+	v := &validator{configFile: configFile, config: config}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(config, &fields); err != nil {
+		return []types.Diagnostic{locale.JSONError(configFile, config, err)}
+	}
+
+	// Problems that keep the configuration from loading hide the others
+	english := locale.New()
+	loc := locale.New()
+	if diagnostics := loc.Load(configFile, config); len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	v.checkKeys(fields)
+	v.checkKeywords(loc, english)
+	v.checkVerbs(loc, english)
+
+	if !locale.ValidNativeDigits(loc.NativeDigits) {
+		v.report(types.ErrorSeverity, "nativeDigits", "", 0, "LocaleNativeDigitsError", loc.NativeDigits)
+	}
+
+	return v.diagnostics
+}
@@ -25,53 +25,27 @@ SOFTWARE.
 package vm
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"github.com/ivandejanovic/mlpl/codegen"
 	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/tm"
+	"github.com/ivandejanovic/mlpl/types"
+	"io"
+	"math"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	iaddr_size int = 4096
-	daddr_size int = 4096
-	no_regs    int = 8
-	pc_reg     int = 7
-)
-
-type opclass int
-
-const (
-	opcLRR opclass = 1 + iota // reg operands r,s,t
-	opclRM                    // reg r, mem d+s
-	opclRA                    // reg r, int d+s
-)
-
-type opcode int
-
-const (
-	// RR instructions
-	opHALT opcode = 1 + iota // RR     halt, operands are ignored
-	opPRNT                   // RR     print, print operant to console
-	opIN                     // RR     read into reg(r); s and t are ignored
-	opOUT                    // RR     write from reg(r), s and t are ignored
-	opADD                    // RR     reg(r) = reg(s)+reg(t)
-	opSUB                    // RR     reg(r) = reg(s)-reg(t)
-	opMUL                    // RR     reg(r) = reg(s)*reg(t)
-	opDIV                    // RR     reg(r) = reg(s)/reg(t)
-
-	// RM instructions
-	opLD // RM     reg(r) = mem(d+reg(s))
-	opST // RM     mem(d+reg(s)) = reg(r)
-
-	// RA instructions
-	opLDA // RA     reg(r) = d+reg(s)
-	opLDC // RA     reg(r) = d ; reg(s) is ignored
-	opJLT // RA     if reg(r)<0 then reg(7) = d+reg(s)
-	opJLE // RA     if reg(r)<=0 then reg(7) = d+reg(s)
-	opJGT // RA     if reg(r)>0 then reg(7) = d+reg(s)
-	opJGE // RA     if reg(r)>=0 then reg(7) = d+reg(s)
-	opJEQ // RA     if reg(r)==0 then reg(7) = d+reg(s)
-	opJNE // RA     if reg(r)!=0 then reg(7) = d+reg(s)
+	daddr_size int = tm.DataSize
+	no_regs    int = tm.NumRegs
+	pc_reg     int = tm.PC
+	poll_steps int = 1024 // Instructions executed between looks at the context and the timer
 )
 
 type stepRESULT int
@@ -84,274 +58,548 @@ const (
 	srZERODIVIDE
 )
 
-type instruction struct {
-	iop    opcode
-	iarg1  int
-	iarg2  int
-	iarg3  int
-	iargs1 string
+// Limits ends a program that runs too long, a zero field leaves its limit out
+type Limits struct {
+	MaxSteps int64         // Number of instructions the program may execute
+	Timeout  time.Duration // Time the program may run for
+}
+
+// lineRead is a line of input with the error that ended it, as bufio.Reader.ReadString returns them
+type lineRead struct {
+	line string
+	err  error
 }
 
 type vmMem struct {
-	iMem [iaddr_size]instruction
-	dMem [daddr_size]int
-	reg  [no_regs]int
-}
-
-func (vm *vmMem) loadCode(code []string) bool {
-	var (
-		lineNo           int = 0
-		loc              int
-		op               opcode
-		arg1, arg2, arg3 int
-		args1            string
-		err              error
-		ok               bool
-		opcodeMap        map[string]opcode = map[string]opcode{
-			// RR opcodes
-			"HALT":  opHALT,
-			"PRINT": opPRNT,
-			"IN":    opIN,
-			"OUT":   opOUT,
-			"ADD":   opADD,
-			"SUB":   opSUB,
-			"MUL":   opMUL,
-			"DIV":   opDIV,
-
-			// RM instructions
-			"LD": opLD,
-			"ST": opST,
-
-			// RA instructions
-			"LDA": opLDA,
-			"LDC": opLDC,
-			"JLT": opJLT,
-			"JLE": opJLE,
-			"JGT": opJGT,
-			"JGE": opJGE,
-			"JEQ": opJEQ,
-			"JNE": opJNE,
-		}
-	)
+	iMem [iaddr_size]tm.Instruction
+	dMem [daddr_size]int64
+	reg  [no_regs]int64
+
+	// Strings live in a table and registers or memory hold their index. Equal strings share an index, so they compare like integers.
+	strs     []string
+	strIndex map[string]int
+	in       *bufio.Reader
+	pending  chan lineRead // Read the machine stopped waiting for, its line goes to the next read
+	out      io.Writer
+	locale   *locale.LocaleType // Locale of the numbers the program reads and writes
+
+	// Channels of the running program, nil while nothing can stop it
+	done    <-chan struct{}
+	expired <-chan time.Time
+	timeout time.Duration
+
+	lines map[int]codegen.Statement // Source line of the first instruction of every statement, nil when the program has no source
+}
 
-	for _, inst := range code {
-		lineNo++
+// Function intern returns the string table index of s, adding it to the table when it is new
+func (vm *vmMem) intern(s string) int {
+	index, ok := vm.strIndex[s]
+	if !ok {
+		index = len(vm.strs)
+		vm.strs = append(vm.strs, s)
+		vm.strIndex[s] = index
+	}
 
-		instSlice := strings.Split(strings.Trim(inst, " "), ":")
-		if len(instSlice) < 2 {
-			fmt.Printf(locale.Locale.VmMissingColonError, lineNo)
-			return false
-		}
+	return index
+}
 
-		loc, err = strconv.Atoi(strings.Trim(instSlice[0], " "))
-		if err != nil {
-			fmt.Printf(locale.Locale.VmMemoryLocationError, instSlice[0], lineNo)
-			return false
-		}
-		if loc > iaddr_size {
-			fmt.Printf(locale.Locale.VmMemoryToLargeError, loc, lineNo)
-			return false
-		}
+// Function real returns the real number held in register r
+func (vm *vmMem) real(r int) float64 {
+	return math.Float64frombits(uint64(vm.reg[r]))
+}
 
-		opValue := strings.Trim(instSlice[1], " ")
-		opIndex := strings.Index(opValue, " ")
-		if opIndex == -1 {
-			fmt.Printf(locale.Locale.VmMissingOpcodeError, loc, lineNo)
-			return false
-		}
+// Procedure setReal stores a real number into register r
+func (vm *vmMem) setReal(r int, f float64) {
+	vm.reg[r] = int64(math.Float64bits(f))
+}
 
-		opCodeKey := opValue[0:opIndex]
-		args := strings.Trim(opValue[opIndex+1:len(opValue)], " ")
-		op, ok = opcodeMap[opCodeKey]
-		if !ok {
-			fmt.Println(inst)
-			fmt.Println(opCodeKey)
-			fmt.Printf(locale.Locale.VmInvalidOpcodeError, loc, lineNo)
-			return false
-		}
+// Function formatReal writes a real number rounded to 15 significant digits with the decimal separator and digits of the locale
+func (vm *vmMem) formatReal(f float64) string {
+	return vm.locale.WriteDigits(strings.Replace(strconv.FormatFloat(f, 'g', 15, 64), ".", vm.locale.DecimalSeparator, 1))
+}
 
-		switch op {
-		case opHALT, opIN, opOUT, opADD, opSUB, opMUL, opDIV:
-			argsSlice := strings.Split(args, ",")
-			if len(argsSlice) != 3 {
-				fmt.Printf(locale.Locale.VmInvalidNumberOfArgumentsError, loc, lineNo)
-				return false
-			}
+// Function parseReal reads a real number written with either a decimal point or the decimal separator of the locale, in the digits of any script
+func (vm *vmMem) parseReal(s string) (float64, error) {
+	s = strings.Replace(locale.ASCIIDigits(strings.TrimSpace(s)), vm.locale.DecimalSeparator, ".", 1)
+	return strconv.ParseFloat(s, 64)
+}
 
-			arg1, err = strconv.Atoi(strings.Trim(argsSlice[0], " "))
-			if err != nil {
-				fmt.Printf(locale.Locale.VmInvalidFirstArgumentError, loc, lineNo)
-				return false
-			}
+// Function formatInt writes an integer with the digits of the locale
+func (vm *vmMem) formatInt(value int64) string {
+	return vm.locale.WriteDigits(strconv.FormatInt(value, 10))
+}
 
-			arg2, err = strconv.Atoi(strings.Trim(argsSlice[1], " "))
-			if err != nil {
-				fmt.Printf(locale.Locale.VmInvalidSecondArgumentError, loc, lineNo)
-				return false
-			}
+/*
+Function await waits for the next line of input and returns a diagnostic when the program is cancelled or times out first.
+The line is read in the background, a read the machine stopped waiting for stays pending so its line is neither lost nor read twice.
+*/
+func (vm *vmMem) await() (lineRead, []types.Diagnostic) {
+	if vm.pending == nil {
+		in, pending := vm.in, make(chan lineRead, 1)
+		go func() {
+			line, err := in.ReadString('\n')
+			pending <- lineRead{line, err}
+		}()
+		vm.pending = pending
+	}
 
-			arg3, err = strconv.Atoi(strings.Trim(argsSlice[2], " "))
-			if err != nil {
-				fmt.Printf(locale.Locale.VmInvalidThirdArgumentError, loc, lineNo)
-				return false
-			}
+	select {
+	case read := <-vm.pending:
+		vm.pending = nil
+		return read, nil
+	case <-vm.done:
+		return lineRead{}, vmError("VmCancelledError")
+	case <-vm.expired:
+		return lineRead{}, vmError("VmTimeoutError", vm.timeout)
+	}
+}
 
-			vm.iMem[loc].iop = op
-			vm.iMem[loc].iarg1 = arg1
-			vm.iMem[loc].iarg2 = arg2
-			vm.iMem[loc].iarg3 = arg3
-		case opLD, opST, opLDA, opLDC, opJLT, opJLE, opJGT, opJGE, opJEQ, opJNE:
-			argsSlice1 := strings.Split(args, ",")
-			if len(argsSlice1) != 2 {
-				fmt.Printf(locale.Locale.VmInvalidNumberOfArgumentsError, loc, lineNo)
-				return false
-			}
+/*
+Procedure stopInput ends a read the machine stopped waiting for when the input of a program can be interrupted.
+A past read deadline interrupts a reader with SetReadDeadline, any other closable reader but a file is closed, and the read is waited for.
+A file without deadlines cannot be interrupted, closing it does not end a read that blocks, so its read is left to end with the next line.
+*/
+func (vm *vmMem) stopInput(source io.Reader) {
+	if vm.pending == nil {
+		return
+	}
 
-			argsSlice2 := strings.Split(argsSlice1[1], "(")
-			if len(argsSlice2) != 2 {
-				fmt.Printf(locale.Locale.VmInvalidNumberOfArgumentsError, loc, lineNo)
-				return false
-			}
+	_, isFile := source.(*os.File)
+	if input, ok := source.(interface{ SetReadDeadline(time.Time) error }); ok && input.SetReadDeadline(time.Now()) == nil {
+		<-vm.pending
+		input.SetReadDeadline(time.Time{})
+	} else if input, ok := source.(io.Closer); ok && !isFile {
+		input.Close()
+		<-vm.pending
+	}
+	vm.pending = nil
+}
 
-			arg1, err = strconv.Atoi(strings.Trim(argsSlice1[0], " "))
-			if err != nil {
-				fmt.Printf(locale.Locale.VmInvalidFirstArgumentError, loc, lineNo)
-				return false
-			}
+// Function readLine reads one line of input without the line ending, false at the end of input or with the diagnostic of a program stopped while waiting
+func (vm *vmMem) readLine() (string, bool, []types.Diagnostic) {
+	read, diagnostics := vm.await()
+	if diagnostics != nil || read.err != nil && (read.err != io.EOF || read.line == "") {
+		return "", false, diagnostics
+	}
 
-			arg2, err = strconv.Atoi(strings.Trim(argsSlice2[0], " "))
-			if err != nil {
-				fmt.Printf(locale.Locale.VmInvalidSecondArgumentError, loc, lineNo)
-				return false
-			}
+	return strings.TrimRight(read.line, "\r\n"), true, nil
+}
+
+// Function vmError returns the diagnostic of an error that stops the machine, step gives it the line of the failing instruction
+func vmError(key string, args ...interface{}) []types.Diagnostic {
+	return []types.Diagnostic{{Severity: types.ErrorSeverity, Key: key, Args: args}}
+}
+
+// Function str returns the string held in register r, false when it holds no string table index
+func (vm *vmMem) str(r int) (string, bool) {
+	if vm.reg[r] < 0 || vm.reg[r] >= int64(len(vm.strs)) {
+		return "", false
+	}
+
+	return vm.strs[vm.reg[r]], true
+}
+
+// Function line returns the source line of the statement the instruction at location pc belongs to, zero when it is not known
+func (vm *vmMem) line(pc int) int {
+	// Statements are generated in order, so the last one starting at or before pc holds it
+	start, line := -1, 0
+	for loc, statement := range vm.lines {
+		if loc <= pc && loc > start {
+			start, line = loc, statement.Line
+		}
+	}
+
+	return line
+}
+
+// Function locate sets the source line of the instruction at location pc on diagnostics and returns them
+func (vm *vmMem) locate(pc int, diagnostics []types.Diagnostic) []types.Diagnostic {
+	for index := range diagnostics {
+		diagnostics[index].Line = vm.line(pc)
+	}
+
+	return diagnostics
+}
 
-			arg3, err = strconv.Atoi(strings.Trim(argsSlice2[1], ")"))
-			if err != nil {
-				fmt.Printf(locale.Locale.VmInvalidThirdArgumentError, loc, lineNo)
-				return false
+// Function loadCode places a program into instruction memory, checking that every instruction fits, has a valid opcode and names existing registers
+func (vm *vmMem) loadCode(code []tm.Instruction) []types.Diagnostic {
+	for index, inst := range code {
+		if inst.Loc < 0 || inst.Loc >= iaddr_size {
+			return vmError("VmMemoryToLargeError", inst.Loc, index+1)
+		}
+		if !inst.Op.Valid() {
+			return vmError("VmInvalidOpcodeError", inst.Loc, index+1)
+		}
+		for _, reg := range inst.Registers() {
+			if reg < 0 || reg >= no_regs {
+				return vmError("VmInvalidRegisterError", reg, inst.Loc, index+1)
 			}
-		case opPRNT:
-			args1 = args
 		}
 
-		vm.iMem[loc].iop = op
-		vm.iMem[loc].iarg1 = arg1
-		vm.iMem[loc].iarg2 = arg2
-		vm.iMem[loc].iarg3 = arg3
-		vm.iMem[loc].iargs1 = args1
+		vm.iMem[inst.Loc] = inst
 	}
-	return true
+
+	return nil
+}
+
+// Function step executes one instruction, it returns true once the machine has stopped at HALT or on an error positioned at the line of the instruction
+func (vm *vmMem) step() (bool, []types.Diagnostic) {
+	pc := int(vm.reg[pc_reg])
+	stopped, diagnostics := vm.execute()
+
+	return stopped, vm.locate(pc, diagnostics)
 }
 
-func (vm *vmMem) executeCode() {
-	var execute bool = true
+// Function execute executes one instruction, it returns true once the machine has stopped at HALT or on an error
+func (vm *vmMem) execute() (bool, []types.Diagnostic) {
+	var r, s, t, m int = 0, 0, 0, 0
+	var str string = ""
+	pc := int(vm.reg[pc_reg])
+	if pc < 0 || pc >= iaddr_size {
+		return true, vmError("VmInvalidProgramCounterError", pc)
+	}
 
-	for execute {
-		var r, s, t, m int = 0, 0, 0, 0
-		var str string = ""
-		pc := vm.reg[pc_reg]
-		if pc < 0 || pc > iaddr_size {
-			fmt.Printf(locale.Locale.VmInvalidProgramCounterError, pc)
-			return
+	vm.reg[pc_reg] = int64(pc + 1)
+	inst := vm.iMem[pc]
+
+	//Setup instruction arguments
+	switch inst.Op {
+	case tm.HALT, tm.IN, tm.OUT, tm.ADD, tm.SUB, tm.MUL, tm.DIV, tm.CAT, tm.STR, tm.INS, tm.OUTS,
+		tm.ADDF, tm.SUBF, tm.MULF, tm.DIVF, tm.FLT, tm.CMPF, tm.INF, tm.OUTF, tm.STRF:
+		r = inst.Arg1
+		s = inst.Arg2
+		t = inst.Arg3
+	case tm.LD, tm.ST:
+		r = inst.Arg1
+		s = inst.Arg3
+		m = inst.Arg2 + int(vm.reg[s])
+
+		if m < 0 || m >= daddr_size {
+			return true, vmError("VmInvalidMemoryAddressError", m)
 		}
+	case tm.LDA, tm.LDC, tm.JLT, tm.JLE, tm.JGT, tm.JGE, tm.JEQ, tm.JNE, tm.CHK, tm.STK:
+		r = inst.Arg1
+		s = inst.Arg3
+		m = inst.Arg2 + int(vm.reg[s])
+	case tm.PRINT:
+		str = inst.Str
+	case tm.LDS:
+		r = inst.Arg1
+		str = inst.Str
+	case tm.LDCF:
+		r = inst.Arg1
+	}
 
-		vm.reg[pc_reg] = pc + 1
-		inst := vm.iMem[pc]
-
-		//Setup instruction arguments
-		switch inst.iop {
-		case opHALT, opIN, opOUT, opADD, opSUB, opMUL, opDIV:
-			r = inst.iarg1
-			s = inst.iarg2
-			t = inst.iarg3
-		case opLD, opST:
-			r = inst.iarg1
-			s = inst.iarg3
-			m = inst.iarg2 + vm.reg[s]
-
-			if m < 0 || m > daddr_size {
-				fmt.Printf(locale.Locale.VmInvalidMemoryAddressError, m)
-				return
-			}
-		case opLDA, opLDC, opJLT, opJLE, opJGT, opJGE, opJEQ, opJNE:
-			r = inst.iarg1
-			s = inst.iarg3
-			m = inst.iarg2 + vm.reg[s]
-		case opPRNT:
-			str = inst.iargs1
+	//Execute instruction
+	switch inst.Op {
+	case tm.HALT:
+		return true, nil
+	case tm.PRINT:
+		fmt.Fprintln(vm.out, str)
+	case tm.IN:
+		line, _, diagnostics := vm.readLine()
+		if diagnostics != nil {
+			return true, diagnostics
 		}
+		num, err := strconv.ParseInt(locale.ASCIIDigits(strings.TrimSpace(line)), 10, 64)
+		if err != nil {
+			return true, vmError("VmNonIntegerEnteredError")
+		}
+		vm.reg[r] = num
+	case tm.OUT:
+		fmt.Fprintln(vm.out, vm.formatInt(vm.reg[r]))
+	case tm.INS:
+		line, ok, diagnostics := vm.readLine()
+		if diagnostics != nil {
+			return true, diagnostics
+		}
+		if !ok {
+			return true, vmError("VmEndOfInputError")
+		}
+		vm.reg[r] = int64(vm.intern(line))
+	case tm.OUTS:
+		text, ok := vm.str(r)
+		if !ok {
+			return true, vmError("VmInvalidStringError", vm.reg[r])
+		}
+		fmt.Fprintln(vm.out, text)
+	case tm.CAT:
+		left, ok := vm.str(s)
+		if !ok {
+			return true, vmError("VmInvalidStringError", vm.reg[s])
+		}
+		right, ok := vm.str(t)
+		if !ok {
+			return true, vmError("VmInvalidStringError", vm.reg[t])
+		}
+		vm.reg[r] = int64(vm.intern(left + right))
+	case tm.STR:
+		vm.reg[r] = int64(vm.intern(vm.formatInt(vm.reg[s])))
+	case tm.LDS:
+		vm.reg[r] = int64(vm.intern(str))
+	case tm.LDCF:
+		vm.setReal(r, inst.Real)
+	case tm.ADDF:
+		vm.setReal(r, vm.real(s)+vm.real(t))
+	case tm.SUBF:
+		vm.setReal(r, vm.real(s)-vm.real(t))
+	case tm.MULF:
+		vm.setReal(r, vm.real(s)*vm.real(t))
+	case tm.DIVF:
+		if vm.real(t) == 0 {
+			return true, vmError("VmDivisionWIthZeroError")
+		}
+		vm.setReal(r, vm.real(s)/vm.real(t))
+	case tm.FLT:
+		vm.setReal(r, float64(vm.reg[s]))
+	case tm.CMPF:
+		if vm.real(s) < vm.real(t) {
+			vm.reg[r] = -1
+		} else if vm.real(s) > vm.real(t) {
+			vm.reg[r] = 1
+		} else {
+			vm.reg[r] = 0
+		}
+	case tm.INF:
+		line, _, diagnostics := vm.readLine()
+		if diagnostics != nil {
+			return true, diagnostics
+		}
+		num, err := vm.parseReal(line)
+		if err != nil {
+			return true, vmError("VmNonNumberEnteredError")
+		}
+		vm.setReal(r, num)
+	case tm.OUTF:
+		fmt.Fprintln(vm.out, vm.formatReal(vm.real(r)))
+	case tm.STRF:
+		vm.reg[r] = int64(vm.intern(vm.formatReal(vm.real(s))))
+	case tm.ADD:
+		vm.reg[r] = vm.reg[s] + vm.reg[t]
+	case tm.SUB:
+		vm.reg[r] = vm.reg[s] - vm.reg[t]
+	case tm.MUL:
+		vm.reg[r] = vm.reg[s] * vm.reg[t]
+	case tm.DIV:
+		if vm.reg[t] == 0 {
+			return true, vmError("VmDivisionWIthZeroError")
+		}
+		vm.reg[r] = vm.reg[s] / vm.reg[t]
+	case tm.LD:
+		vm.reg[r] = vm.dMem[m]
+	case tm.ST:
+		vm.dMem[m] = vm.reg[r]
+	case tm.LDA:
+		vm.reg[r] = int64(m)
+	case tm.LDC:
+		vm.reg[r] = int64(inst.Arg2)
+	case tm.JLT:
+		if vm.reg[r] < 0 {
+			vm.reg[pc_reg] = int64(m)
+		}
+	case tm.JLE:
+		if vm.reg[r] <= 0 {
+			vm.reg[pc_reg] = int64(m)
+		}
+	case tm.JGT:
+		if vm.reg[r] > 0 {
+			vm.reg[pc_reg] = int64(m)
+		}
+	case tm.JGE:
+		if vm.reg[r] >= 0 {
+			vm.reg[pc_reg] = int64(m)
+		}
+	case tm.JEQ:
+		if vm.reg[r] == 0 {
+			vm.reg[pc_reg] = int64(m)
+		}
+	case tm.JNE:
+		if vm.reg[r] != 0 {
+			vm.reg[pc_reg] = int64(m)
+		}
+	case tm.CHK:
+		if vm.reg[r] < 0 || vm.reg[r] >= int64(inst.Arg2) {
+			return true, vmError("VmIndexOutOfRangeError", vm.reg[r], inst.Arg2)
+		}
+	case tm.STK:
+		if vm.reg[r] < int64(m) {
+			return true, vmError("VmStackOverflowError")
+		}
+	}
 
-		//Execute instruction
-		switch inst.iop {
-		case opHALT:
-			return
-		case opPRNT:
-			fmt.Println(str)
-		case opIN:
-			var num int = 0
-			_, err := fmt.Scanf("%d", &num)
-			if err != nil {
-				fmt.Println(locale.Locale.VmNonIntegerEnteredError)
-				return
-			}
-			vm.reg[r] = num
-		case opOUT:
-			fmt.Println(vm.reg[r])
-		case opADD:
-			vm.reg[r] = vm.reg[s] + vm.reg[t]
-		case opSUB:
-			vm.reg[r] = vm.reg[s] - vm.reg[t]
-		case opMUL:
-			vm.reg[r] = vm.reg[s] * vm.reg[t]
-		case opDIV:
-			if vm.reg[t] == 0 {
-				fmt.Println(locale.Locale.VmDivisionWIthZeroError)
-				return
-			}
-			vm.reg[r] = vm.reg[s] / vm.reg[t]
-		case opLD:
-			vm.reg[r] = vm.dMem[m]
-		case opST:
-			vm.dMem[m] = vm.reg[r]
-		case opLDA:
-			vm.reg[r] = m
-		case opLDC:
-			vm.reg[r] = inst.iarg2
-		case opJLT:
-			if vm.reg[r] < 0 {
-				vm.reg[pc_reg] = m
-			}
-		case opJLE:
-			if vm.reg[r] <= 0 {
-				vm.reg[pc_reg] = m
-			}
-		case opJGT:
-			if vm.reg[r] > 0 {
-				vm.reg[pc_reg] = m
-			}
-		case opJGE:
-			if vm.reg[r] >= 0 {
-				vm.reg[pc_reg] = m
-			}
-		case opJEQ:
-			if vm.reg[r] == 0 {
-				vm.reg[pc_reg] = m
-			}
-		case opJNE:
-			if vm.reg[r] != 0 {
-				vm.reg[pc_reg] = m
+	return false, nil
+}
+
+/*
+Function executeCode runs the machine until it stops, the context is done or it goes over one of the limits.
+The context and the timer are looked at every poll_steps instructions and while the program waits for input.
+*/
+func (vm *vmMem) executeCode(ctx context.Context, limits Limits) []types.Diagnostic {
+	vm.done, vm.timeout = ctx.Done(), limits.Timeout
+	defer func() { vm.done, vm.expired = nil, nil }()
+
+	// A nil channel is never ready, so without a timeout only the context is watched
+	if limits.Timeout > 0 {
+		timer := time.NewTimer(limits.Timeout)
+		defer timer.Stop()
+		vm.expired = timer.C
+	}
+
+	for steps := int64(0); ; steps++ {
+		pc := int(vm.reg[pc_reg])
+		if steps%int64(poll_steps) == 0 {
+			select {
+			case <-vm.done:
+				return vm.locate(pc, vmError("VmCancelledError"))
+			case <-vm.expired:
+				return vm.locate(pc, vmError("VmTimeoutError", limits.Timeout))
+			default:
 			}
 		}
+
+		if limits.MaxSteps > 0 && steps >= limits.MaxSteps {
+			return vm.locate(pc, vmError("VmStepLimitError", limits.MaxSteps))
+		}
+
+		if stopped, diagnostics := vm.step(); stopped {
+			return diagnostics
+		}
 	}
 }
 
-func Execute(code []string) {
+// Function newVmMem returns a machine with empty memory that reads its input from in and writes its output to out in a locale
+func newVmMem(in *bufio.Reader, out io.Writer, loc *locale.LocaleType) *vmMem {
 	vm := new(vmMem)
-	vm.dMem[0] = daddr_size - 1
+	vm.dMem[0] = int64(daddr_size - 1)
+	vm.strIndex = make(map[string]int)
+	vm.intern("") // Index zero is the empty string, the value of a fresh string variable
+	vm.in = in
+	vm.out = out
+	vm.locale = loc
+
+	return vm
+}
 
-	if !vm.loadCode(code) {
-		return
+func Execute(code []tm.Instruction) []types.Diagnostic {
+	return Run(context.Background(), code, nil, os.Stdin, os.Stdout, locale.Locale, Limits{})
+}
+
+/*
+Function Run runs a program on a machine of its own with the given input, output and locale until it halts, fails, goes over a limit or the context is done.
+Runtime errors are positioned with the line table of the program, which may be nil.
+When the program is stopped while it waits for input, the read is interrupted with a read deadline, or by closing in when in is a closer
+other than a file, before Run returns. Only the read of an input that allows neither goes on until its next line arrives.
+*/
+func Run(ctx context.Context, code []tm.Instruction, lines map[int]codegen.Statement, in io.Reader, out io.Writer, loc *locale.LocaleType, limits Limits) []types.Diagnostic {
+	vm := newVmMem(bufio.NewReader(in), out, loc)
+	vm.lines = lines
+	defer vm.stopInput(in)
+
+	if diagnostics := vm.loadCode(code); diagnostics != nil {
+		return diagnostics
+	}
+
+	return vm.executeCode(ctx, limits)
+}
+
+// Session keeps memory, strings and registers of the machine between program fragments run by the interactive mode
+type Session struct {
+	vm     *vmMem
+	reg    [no_regs]int64 // Registers as left by the last fragment that halted normally
+	limits Limits         // Limits of each fragment on its own
+}
+
+// Function NewSession returns a session with empty memory that reads from in and limits every fragment it runs, a caller reading the same input goes through ReadString
+func NewSession(in *bufio.Reader, limits Limits) *Session {
+	vm := newVmMem(in, os.Stdout, locale.Locale)
+
+	return &Session{vm, vm.reg, limits}
+}
+
+// Function ReadString reads the next line of the input shared with the caller as bufio.Reader.ReadString does, taking over a line a fragment stopped waiting for
+func (session *Session) ReadString() (string, error) {
+	read, _ := session.vm.await()
+
+	return read.line, read.err
+}
+
+/*
+Function Execute loads a program fragment and runs it from location start.
+Registers are restored from the last fragment that halted, so a runtime error inside a procedure does not leave the frame and temp stack pointers behind.
+*/
+func (session *Session) Execute(code []tm.Instruction, start int) []types.Diagnostic {
+	vm := session.vm
+	vm.reg = session.reg
+	vm.reg[pc_reg] = int64(start)
+
+	if diagnostics := vm.loadCode(code); diagnostics != nil {
+		return diagnostics
+	}
+
+	diagnostics := vm.executeCode(context.Background(), session.limits)
+	if diagnostics == nil {
+		session.reg = vm.reg
+	}
+
+	return diagnostics
+}
+
+// Process is a program loaded into a machine of its own, run one instruction at a time by the debugger
+type Process struct {
+	vm *vmMem
+}
+
+// Function Load loads a program with its line table into a new machine that shares the reader in with its caller
+func Load(code []tm.Instruction, lines map[int]codegen.Statement, in *bufio.Reader) (*Process, []types.Diagnostic) {
+	vm := newVmMem(in, os.Stdout, locale.Locale)
+	vm.lines = lines
+
+	if diagnostics := vm.loadCode(code); diagnostics != nil {
+		return nil, diagnostics
+	}
+
+	return &Process{vm}, nil
+}
+
+// Function Step executes one instruction, it returns true once the program has stopped at HALT or on an error
+func (process *Process) Step() (bool, []types.Diagnostic) {
+	return process.vm.step()
+}
+
+// Function PC returns the location of the next instruction to execute
+func (process *Process) PC() int {
+	return int(process.vm.reg[pc_reg])
+}
+
+// Function Register returns the value held in register r
+func (process *Process) Register(r int) int64 {
+	return process.vm.reg[r]
+}
+
+// Function Memory returns the value held at a data memory address, false when the address is outside memory
+func (process *Process) Memory(address int) (int64, bool) {
+	if address < 0 || address >= daddr_size {
+		return 0, false
+	}
+
+	return process.vm.dMem[address], true
+}
+
+// Function Format returns a value of the given type as the program would write it
+func (process *Process) Format(value int64, expType types.ExpType) string {
+	switch expType {
+	case types.Real:
+		return process.vm.formatReal(math.Float64frombits(uint64(value)))
+	case types.String:
+		if value >= 0 && value < int64(len(process.vm.strs)) {
+			return process.vm.strs[value]
+		}
+	case types.Boolean:
+		if value != 0 {
+			return process.vm.locale.Keyword(types.TRUE)
+		}
+		return process.vm.locale.Keyword(types.FALSE)
 	}
 
-	vm.executeCode()
+	return process.vm.formatInt(value)
 }
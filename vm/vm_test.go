@@ -0,0 +1,190 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016-2024 Ivan Dejanovic
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/ivandejanovic/mlpl/analyze"
+	"github.com/ivandejanovic/mlpl/codegen"
+	"github.com/ivandejanovic/mlpl/lexer"
+	"github.com/ivandejanovic/mlpl/locale"
+	"github.com/ivandejanovic/mlpl/parse"
+	"github.com/ivandejanovic/mlpl/tm"
+	"github.com/ivandejanovic/mlpl/types"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Function compile translates English source into instructions with their line table, failing the test on any diagnostic
+func compile(t *testing.T, source string) ([]tm.Instruction, map[int]codegen.Statement) {
+	loc := locale.New()
+	tokens, diagnostics := parse.ParseReader(strings.NewReader(source), loc)
+	treeNode, lexDiagnostics := lexer.Lex(tokens, loc)
+	diagnostics = append(diagnostics, lexDiagnostics...)
+	bucketMap, symtabDiagnostics := analyze.BuildSymtab(treeNode)
+	diagnostics = append(diagnostics, symtabDiagnostics...)
+	diagnostics = append(diagnostics, analyze.TypeCheck(treeNode, bucketMap)...)
+	if len(diagnostics) > 0 {
+		t.Fatalf("%q: compiling reported %v", source, diagnostics)
+	}
+
+	generator := codegen.NewGenerator(loc)
+	code, _, diagnostics := generator.Generate(treeNode, bucketMap)
+	if len(diagnostics) > 0 {
+		t.Fatalf("%q: code generation reported %v", source, diagnostics)
+	}
+
+	return code, generator.LineTable()
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		source string
+		input  string
+		output string
+	}{
+		{"write 1 + 2 * 3;", "", "7\n"},
+		{"read x;\nwrite x * x;", "12\n", "144\n"},
+		{"x := 1.5;\nwrite x * 2;", "", "3\n"},
+		{"s := \"a:b, (c)\";\nwrite s + \"!\";", "", "a:b, (c)!\n"},
+		{"i := 0;\nrepeat\n  i := i + 1;\nuntil i = 5\nwrite i;", "", "5\n"},
+		{"array a[3];\na[2] := 4;\nwrite a[2];", "", "4\n"},
+		{"procedure f(n)\n  if n < 2 then\n    return 1;\n  end\n  return n * f(n - 1);\nend\nwrite f(5);", "", "120\n"},
+	}
+
+	for _, test := range tests {
+		code, lines := compile(t, test.source)
+		var out bytes.Buffer
+		diagnostics := Run(context.Background(), code, lines, strings.NewReader(test.input), &out, locale.New(), Limits{})
+		if len(diagnostics) > 0 {
+			t.Errorf("%q: Run reported %v", test.source, diagnostics)
+		}
+		if out.String() != test.output {
+			t.Errorf("%q: Run wrote %q, want %q", test.source, out.String(), test.output)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		key    string
+		line   int
+	}{
+		{"x := 0;\nwrite 1 / x;", "VmDivisionWIthZeroError", 2},
+		{"array a[3];\ni := 3;\na[i] := 1;", "VmIndexOutOfRangeError", 3},
+		{"procedure r(n)\n  return r(n + 1);\nend\nwrite r(0);", "VmStackOverflowError", 1},
+	}
+
+	for _, test := range tests {
+		code, lines := compile(t, test.source)
+		diagnostics := Run(context.Background(), code, lines, strings.NewReader(""), &bytes.Buffer{}, locale.New(), Limits{})
+		if len(diagnostics) != 1 || diagnostics[0].Key != test.key || diagnostics[0].Line != test.line {
+			t.Errorf("%q: Run reported %v, want %s on line %d", test.source, diagnostics, test.key, test.line)
+		}
+	}
+}
+
+func TestSessionStackLimit(t *testing.T) {
+	loc := locale.New()
+	generator := codegen.NewGenerator(loc)
+	bucketMap := make(map[string]types.Bucket)
+	session := NewSession(bufio.NewReader(strings.NewReader("")), Limits{})
+
+	// The array of the second fragment takes memory the stack of the procedure from the first one could reach
+	fragments := []string{
+		"procedure r(n)\n  if n = 0 then\n    return 0;\n  end\n  return r(n - 1) + 1;\nend\nx := r(100);",
+		"array a[3900];\na[3899] := 7;\nx := r(100);",
+	}
+	var diagnostics []types.Diagnostic
+	for _, fragment := range fragments {
+		tokens, _ := parse.ParseReader(strings.NewReader(fragment), loc)
+		treeNode, _ := lexer.Lex(tokens, loc)
+		analyze.ExtendSymtab(treeNode, bucketMap)
+		if typeDiagnostics := analyze.TypeCheck(treeNode, bucketMap); len(typeDiagnostics) > 0 {
+			t.Fatalf("%q: type checking reported %v", fragment, typeDiagnostics)
+		}
+		code, start, _ := generator.Generate(treeNode, bucketMap)
+		diagnostics = session.Execute(code, start)
+	}
+
+	if len(diagnostics) != 1 || diagnostics[0].Key != "VmStackOverflowError" {
+		t.Errorf("Execute reported %v, want a stack overflow", diagnostics)
+	}
+	if session.vm.dMem[bucketMap["a"].MemLoc+3899] != 7 {
+		t.Error("the stack overwrote the array")
+	}
+}
+
+func TestRunStopsWaitingForInput(t *testing.T) {
+	code, lines := compile(t, "read x;\nwrite x;")
+	limits := Limits{Timeout: 10 * time.Millisecond}
+	before := runtime.NumGoroutine()
+
+	for run := 0; run < 20; run++ {
+		reader, writer := io.Pipe()
+		diagnostics := Run(context.Background(), code, lines, reader, &bytes.Buffer{}, locale.New(), limits)
+		if len(diagnostics) != 1 || diagnostics[0].Key != "VmTimeoutError" {
+			t.Fatalf("Run reported %v, want a timeout", diagnostics)
+		}
+		if _, err := writer.Write([]byte("1\n")); err != io.ErrClosedPipe {
+			t.Errorf("the input is still open after Run, writing returned %v", err)
+		}
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines were left reading input", after-before)
+	}
+}
+
+func TestRunInterruptsReadWithDeadline(t *testing.T) {
+	code, lines := compile(t, "read x;\nwrite x;")
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Skip("no pipes: ", err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	diagnostics := Run(ctx, code, lines, reader, &bytes.Buffer{}, locale.New(), Limits{})
+	if len(diagnostics) != 1 || diagnostics[0].Key != "VmCancelledError" {
+		t.Fatalf("Run reported %v, want a cancellation", diagnostics)
+	}
+
+	// The input stays open and without a deadline for the next program
+	var out bytes.Buffer
+	writer.Write([]byte("42\n"))
+	if diagnostics := Run(context.Background(), code, lines, reader, &out, locale.New(), Limits{}); len(diagnostics) > 0 || out.String() != "42\n" {
+		t.Errorf("the next Run reported %v and wrote %q", diagnostics, out.String())
+	}
+}